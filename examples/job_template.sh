@@ -0,0 +1,22 @@
+#PBS -joe -o localhost:/tmp
+{{/*
+Job script template for the "template" setting of "job_script".
+Available variables: .Namespace, .Name, .UID, .Ncpus, .Mem, .APIServer,
+.PollInterval, and the .Prologue and .Epilogue hooks of the pod's
+PBSJobTemplate. In job arrays, .Name and .UID are $PODNAME and $PODUID,
+set below from .Pods.
+*/ -}}
+{{if .Pods}}case "$PBS_ARRAY_INDEX" in
+{{range .Pods}}{{.Index}}) PODNAME={{.Name}} PODUID={{.UID}} ;;
+{{end}}esac
+{{end -}}
+sleep 30
+while :
+do
+	docker ps | grep {{.Name}}
+	if [ $? -ne 0 ]; then
+		exit 0
+	else
+		sleep {{.PollInterval}}
+	fi
+done
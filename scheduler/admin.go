@@ -0,0 +1,418 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// managedAnnotation set to "false" detaches a pod from PBS: the scheduler
+// leaves it alone.
+const managedAnnotation = "PBSManaged"
+
+// PodJobStatus is a pod managed by the scheduler together with its PBS job,
+// as reported by the status command.
+type PodJobStatus struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	JobID     string `json:"jobId"`
+	State     string `json:"state"`
+	Queue     string `json:"queue"`
+	ExecHost  string `json:"execHost"`
+	Node      string `json:"node"`
+	Phase     string `json:"phase"`
+	Age       string `json:"age"`
+	Mismatch  string `json:"mismatch,omitempty"`
+}
+
+// mismatch describes an inconsistency between a pod and its PBS job, or
+// returns "". Pods that terminated are never flagged: their job finished or
+// was dropped from the history of the server.
+func mismatch(pod *Pod, status *JobStatus) string {
+	if pod.Status.Phase == "Succeeded" || pod.Status.Phase == "Failed" {
+		return ""
+	}
+	if status == nil {
+		return "job not found"
+	}
+	running := status.State == "R" || status.State == "E"
+	switch {
+	case pod.Spec.NodeName != "" && !running:
+		return "pod bound but job not running"
+	case pod.Spec.NodeName == "" && status.State == "R" && status.Substate == "42":
+		return "job running but pod pending"
+	case pod.Spec.NodeName != "" && status.ExecNode() != pod.Spec.NodeName:
+		return fmt.Sprintf("pod bound to %s but job runs on %s", pod.Spec.NodeName, status.ExecNode())
+	}
+	return ""
+}
+
+// podJobStatuses returns the status of every pod with a PBS job.
+func podJobStatuses() ([]PodJobStatus, error) {
+	pods, err := getPods("spec.schedulerName=" + schedulerName())
+	if err != nil {
+		return nil, err
+	}
+	var statuses []PodJobStatus
+	for _, pod := range pods.Items {
+		jobid := pod.Metadata.Annotations["JobID"]
+		if jobid == "" {
+			continue
+		}
+		status, err := pbsServer.Status(jobid)
+		if err != nil && err != errUnknownJob {
+			return nil, err
+		}
+		s := PodJobStatus{
+			Namespace: namespaceOf(&pod),
+			Pod:       pod.Metadata.Name,
+			JobID:     jobid,
+			Node:      pod.Spec.NodeName,
+			Phase:     pod.Status.Phase,
+			Age:       age(pod.Metadata.CreationTimestamp),
+			Mismatch:  mismatch(&pod, status),
+		}
+		if status != nil {
+			s.State = status.State
+			s.Queue = status.Queue
+			s.ExecHost = status.ExecHost
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// age formats the time elapsed since an RFC 3339 timestamp as kubectl does.
+func age(timestamp string) string {
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return "<unknown>"
+	}
+	d := time.Since(t)
+	switch {
+	case d < 2*time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < 2*time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
+
+// runStatus implements the status command.
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	output := fs.String("o", "text", "output format: text or json")
+	onlyMismatches := fs.Bool("mismatches", false, "only list pods that do not match their PBS job")
+	fs.Parse(args)
+
+	statuses, err := podJobStatuses()
+	if err != nil {
+		return err
+	}
+	if *onlyMismatches {
+		var filtered []PodJobStatus
+		for _, s := range statuses {
+			if s.Mismatch != "" {
+				filtered = append(filtered, s)
+			}
+		}
+		statuses = filtered
+	}
+	return writeStatuses(os.Stdout, statuses, *output)
+}
+
+func writeStatuses(w io.Writer, statuses []PodJobStatus, format string) error {
+	switch format {
+	case "json":
+		if statuses == nil {
+			statuses = []PodJobStatus{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(statuses)
+	case "text":
+	default:
+		return fmt.Errorf("status: unknown output format %q", format)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tPOD\tJOBID\tSTATE\tQUEUE\tEXEC HOST\tNODE\tAGE\tMISMATCH")
+	for _, s := range statuses {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.Namespace, s.Pod, s.JobID, dash(s.State), dash(s.Queue), dash(s.ExecHost), dash(s.Node), s.Age, dash(s.Mismatch))
+	}
+	return tw.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// clearJob removes the PBS job annotations from the pod, plus the extra
+// annotations given.
+func clearJob(pod *Pod, extra map[string]interface{}) error {
+	changes := map[string]interface{}{"JobID": nil}
+	for key := range jobAnnotations(&JobStatus{}) {
+		changes[key] = nil
+	}
+	for key, value := range extra {
+		changes[key] = value
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": changes},
+	}
+	err := patchPod(pod, "", patch)
+	if err != nil {
+		return err
+	}
+	if jobid := pod.Metadata.Annotations["JobID"]; jobid != "" {
+		recordJob(pod, jobid, jobCleared, "", "")
+	}
+	if pod.Metadata.Annotations == nil {
+		pod.Metadata.Annotations = map[string]string{}
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(pod.Metadata.Annotations, key)
+		} else {
+			pod.Metadata.Annotations[key] = value.(string)
+		}
+	}
+	return nil
+}
+
+// podArg parses the flags of a pod command and returns its pod.
+func podArg(fs *flag.FlagSet, args []string) (*Pod, error) {
+	namespace := fs.String("n", "default", "namespace of the pod")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("%s: exactly one pod name is required", fs.Name())
+	}
+	return getPod(*namespace, fs.Arg(0))
+}
+
+// runResubmit implements the resubmit command: the pod's job is deleted and
+// its annotations cleared, so that the running scheduler submits the pod
+// again to PBS. Submitting it from this process would race with the
+// scheduler's own pass.
+func runResubmit(args []string) error {
+	pod, err := podArg(flag.NewFlagSet("resubmit", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	if pod.Spec.NodeName != "" {
+		return fmt.Errorf("resubmit: pod %s is already bound to %s", pod.Metadata.Name, pod.Spec.NodeName)
+	}
+	jobid := pod.Metadata.Annotations["JobID"]
+	if jobid != "" {
+		err = pbsServer.Delete(jobid)
+		if err != nil && err != errUnknownJob {
+			return err
+		}
+		fmt.Printf("Deleted job %s of pod %s\n", jobid, pod.Metadata.Name)
+	}
+	err = clearJob(pod, map[string]interface{}{managedAnnotation: nil})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Cleared the job of pod %s, the scheduler submits it again on its next pass\n", pod.Metadata.Name)
+	return nil
+}
+
+// runRelease implements the release command, releasing the holds of the
+// pod's job.
+func runRelease(args []string) error {
+	pod, err := podArg(flag.NewFlagSet("release", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	jobid := pod.Metadata.Annotations["JobID"]
+	if jobid == "" {
+		return fmt.Errorf("release: pod %s has no PBS job", pod.Metadata.Name)
+	}
+	err = pbsServer.Release(jobid)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Released job %s of pod %s\n", jobid, pod.Metadata.Name)
+	return nil
+}
+
+// runDetach implements the detach command: the pod is no longer managed by
+// the scheduler, and its job is optionally deleted.
+func runDetach(args []string) error {
+	fs := flag.NewFlagSet("detach", flag.ExitOnError)
+	deleteJob := fs.Bool("delete-job", false, "also delete the pod's PBS job")
+	pod, err := podArg(fs, args)
+	if err != nil {
+		return err
+	}
+	jobid := pod.Metadata.Annotations["JobID"]
+	if jobid != "" && *deleteJob {
+		err = pbsServer.Delete(jobid)
+		if err != nil && err != errUnknownJob {
+			return err
+		}
+		fmt.Printf("Deleted job %s of pod %s\n", jobid, pod.Metadata.Name)
+	}
+	err = clearJob(pod, map[string]interface{}{managedAnnotation: "false"})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Detached pod %s from PBS\n", pod.Metadata.Name)
+	return nil
+}
+
+// runHistory implements the history command, listing the job records of
+// the state store, optionally only those of one pod.
+func runHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	namespace := fs.String("n", "", "only list pods of this namespace")
+	output := fs.String("o", "text", "output format: text or json")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return fmt.Errorf("history: at most one pod name is accepted")
+	}
+
+	records, err := stateStore.Records("")
+	if err != nil {
+		return err
+	}
+	var filtered []JobRecord
+	for _, r := range records {
+		if *namespace != "" && r.Namespace != *namespace {
+			continue
+		}
+		if fs.NArg() == 1 && r.Pod != fs.Arg(0) {
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+
+	switch *output {
+	case "json":
+		if filtered == nil {
+			filtered = []JobRecord{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(filtered)
+	case "text":
+	default:
+		return fmt.Errorf("history: unknown output format %q", *output)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tNAMESPACE\tPOD\tUID\tJOBID\tEVENT\tSTATE\tNODE")
+	for _, r := range filtered {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Time, r.Namespace, r.Pod, r.UID, r.JobID, r.Event, dash(r.State), dash(r.Node))
+	}
+	return tw.Flush()
+}
+
+// runAudit implements the audit command, listing the scheduling decisions
+// of the audit log that match the filters.
+func runAudit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	namespace := fs.String("n", "", "only list pods of this namespace")
+	podName := fs.String("pod", "", "only list decisions about this pod")
+	jobid := fs.String("job", "", "only list decisions about this PBS job")
+	node := fs.String("node", "", "only list decisions binding to this node")
+	outcome := fs.String("outcome", "", "only list decisions with this outcome")
+	since := fs.Duration("since", 0, "only list decisions of this last duration, e.g. 2h")
+	output := fs.String("o", "text", "output format: text or json")
+	fs.Parse(args)
+	if config.Audit.Path == "" {
+		return fmt.Errorf("audit: the audit log is not enabled in the config")
+	}
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("audit: unknown output format %q", *output)
+	}
+
+	var records []AuditRecord
+	err := readAudit(config.Audit, func(r *AuditRecord) {
+		if *since > 0 {
+			t, err := time.Parse(time.RFC3339, r.Time)
+			if err != nil || time.Since(t) > *since {
+				return
+			}
+		}
+		if (*namespace != "" && r.Namespace != *namespace) ||
+			(*podName != "" && r.Pod != *podName) ||
+			(*jobid != "" && r.JobID != *jobid) ||
+			(*node != "" && r.Node != *node) ||
+			(*outcome != "" && r.Outcome != *outcome) {
+			return
+		}
+		records = append(records, *r)
+	})
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		if records == nil {
+			records = []AuditRecord{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tNAMESPACE\tPOD\tJOBID\tJOB STATE\tNODE\tOUTCOME\tERROR")
+	for _, r := range records {
+		state := ""
+		if r.Snapshot != nil {
+			state = r.Snapshot.State
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Time, r.Namespace, r.Pod, dash(r.JobID), dash(state), dash(r.Node), r.Outcome, dash(r.Error))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestMismatch(t *testing.T) {
+	running := &JobStatus{State: "R", Substate: "42", ExecHost: "node1/0"}
+	finished := &JobStatus{State: "F", ExecHost: "node1/0"}
+	tests := []struct {
+		name   string
+		node   string
+		phase  string
+		status *JobStatus
+		want   bool
+	}{
+		{"running", "node1", "Running", running, false},
+		{"bound to another node", "node2", "Running", running, true},
+		{"job finished under a running pod", "node1", "Running", finished, true},
+		{"job running but pod pending", "", "Pending", running, true},
+		{"succeeded", "node1", "Succeeded", finished, false},
+		{"failed", "node1", "Failed", finished, false},
+		{"succeeded without job history", "node1", "Succeeded", nil, false},
+		{"job not found", "", "Pending", nil, true},
+	}
+	for _, tt := range tests {
+		pod := testPod("web", "1", "100Mi")
+		pod.Spec.NodeName = tt.node
+		pod.Status.Phase = tt.phase
+		got := mismatch(&pod, tt.status)
+		if (got != "") != tt.want {
+			t.Errorf("%s: mismatch = %q", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// completionIndexAnnotation holds the index of the pods of indexed Jobs.
+	completionIndexAnnotation = "batch.kubernetes.io/job-completion-index"
+	// jobNameLabel is set by the Job controller on the pods of a Job.
+	jobNameLabel = "job-name"
+	// arrayIndexLabel holds the subjob index of the pods submitted in an
+	// array, so that the PBS hook can find the pod of a subjob.
+	arrayIndexLabel = "PBSArrayIndex"
+	// arrayJobLabel holds the sequence number of the array job of the pods
+	// submitted in an array, as indices restart from 0 in every array.
+	arrayJobLabel = "PBSArrayJob"
+)
+
+// JobArrayConfig selects the pods submitted together as PBS job arrays, in
+// addition to the pods of indexed Jobs.
+type JobArrayConfig struct {
+	// Label groups the pending pods of a namespace with the same value of
+	// the label into an array.
+	Label string `json:"label"`
+}
+
+// jobArray is a set of pending pods submitted as one PBS job array, each
+// pod being the subjob of its index.
+type jobArray struct {
+	name  string
+	label string
+	value string
+	first int
+	last  int
+	pods  map[int]*Pod
+}
+
+// arraySubjobs maps the UIDs of the pods submitted in an array, or whose
+// JobID annotation could not be patched, to their job id until their JobID
+// annotation is seen, so that copies of the pods listed before the
+// submission are not submitted again. In dry run the subjob id is empty.
+var arraySubjobs = map[string]string{}
+
+// arrayMembership returns the label and value grouping the pod into an
+// array and its index, -1 when the index is assigned at submission.
+func arrayMembership(pod *Pod) (string, string, int, bool) {
+	if value, ok := pod.Metadata.Annotations[completionIndexAnnotation]; ok {
+		w := podWorkload(pod)
+		index, err := strconv.Atoi(value)
+		if w != nil && w.Kind == "Job" && err == nil && index >= 0 {
+			return jobNameLabel, w.Name, index, true
+		}
+	}
+	label := config.Arrays.Label
+	if label != "" && pod.Metadata.Labels[label] != "" {
+		return label, pod.Metadata.Labels[label], -1, true
+	}
+	return "", "", 0, false
+}
+
+// podArray returns the array the pod is submitted in: the pending pods of
+// its group translating into the same job request, with contiguous indices
+// around the pod's. The requests of the other pods are computed from the
+// inputs of req. It returns nil when the pod is to be submitted alone.
+func podArray(pod *Pod, req *jobRequest) (*jobArray, error) {
+	label, value, index, ok := arrayMembership(pod)
+	if !ok {
+		return nil, nil
+	}
+	pods, err := getUnscheduledPods()
+	if err != nil {
+		return nil, err
+	}
+
+	var members []*Pod
+	for i := range pods.Items {
+		p := &pods.Items[i]
+		if p.Metadata.Uid == pod.Metadata.Uid {
+			continue
+		}
+		if namespaceOf(p) != namespaceOf(pod) || p.Metadata.Annotations["JobID"] != "" || p.Metadata.DeletionTimestamp != "" || p.Metadata.Annotations[managedAnnotation] == "false" {
+			continue
+		}
+		if _, ok := arraySubjobs[p.Metadata.Uid]; ok {
+			continue
+		}
+		l, v, _, ok := arrayMembership(p)
+		if !ok || l != label || v != value {
+			continue
+		}
+		r, err := req.inputs.request(p)
+		if err != nil || !req.same(r) {
+			continue
+		}
+		members = append(members, p)
+	}
+	members = append(members, pod)
+
+	byIndex := map[int]*Pod{}
+	if index < 0 {
+		sort.Slice(members, func(a, b int) bool {
+			ma, mb := members[a].Metadata, members[b].Metadata
+			if ma.CreationTimestamp != mb.CreationTimestamp {
+				return ma.CreationTimestamp < mb.CreationTimestamp
+			}
+			return ma.Name < mb.Name
+		})
+		for i, p := range members {
+			byIndex[i] = p
+			if p == pod {
+				index = i
+			}
+		}
+	} else {
+		for _, p := range members {
+			_, _, i, _ := arrayMembership(p)
+			if _, ok := byIndex[i]; !ok || p == pod {
+				byIndex[i] = p
+			}
+		}
+	}
+
+	first, last := index, index
+	for byIndex[first-1] != nil {
+		first--
+	}
+	for byIndex[last+1] != nil {
+		last++
+	}
+	if first == last {
+		return nil, nil
+	}
+	array := &jobArray{label: label, value: value, first: first, last: last, pods: map[int]*Pod{}}
+	for i := first; i <= last; i++ {
+		array.pods[i] = byIndex[i]
+	}
+	array.name = value
+	if len(array.name) > maxJobName {
+		array.name = array.name[:maxJobName]
+	}
+	return array, nil
+}
+
+// args returns the qsub option submitting the array.
+func (a *jobArray) args() []string {
+	if a == nil {
+		return nil
+	}
+	return []string{"-J", fmt.Sprintf("%d-%d", a.first, a.last)}
+}
+
+// variables returns the job variables the PBS hook uses to find the pod of
+// a subjob, in the format of qsub -v.
+func (a *jobArray) variables() string {
+	return "PODARRAYLABEL=" + a.label + ",PODARRAYVALUE=" + a.value
+}
+
+// subjobID returns the id of a subjob of an array job.
+func subjobID(jobid string, index int) string {
+	return strings.Replace(jobid, "[]", "["+strconv.Itoa(index)+"]", 1)
+}
+
+// assign maps the pods of the array to their subjob and returns the subjob
+// of pod. The pods are annotated with their subjob id and labelled with
+// their index and array job; a pod failing to be patched is only known to
+// this process and is submitted again after a restart.
+func (a *jobArray) assign(jobid string, pod *Pod) string {
+	sequence := strings.SplitN(jobid, "[", 2)[0]
+	for index, p := range a.pods {
+		subjob := subjobID(jobid, index)
+		arraySubjobs[p.Metadata.Uid] = subjob
+		recordJob(p, subjob, jobSubmitted, "", "")
+		patch := PBSPod{
+			PBSPodMetadata{
+				Labels:      map[string]string{arrayIndexLabel: strconv.Itoa(index), arrayJobLabel: sequence},
+				Annotations: map[string]string{"JobID": subjob},
+			},
+		}
+		err := patchPod(p, "", patch)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	log.Printf("Associating array job %s to %d pods of %s=%s", jobid, len(a.pods), a.label, a.value)
+	return arraySubjobs[pod.Metadata.Uid]
+}
+
+// arraySubjob returns the subjob id assigned to a pod whose copy predates
+// the submission of its array.
+func arraySubjob(pod *Pod) (string, bool) {
+	subjob, ok := arraySubjobs[pod.Metadata.Uid]
+	if ok && pod.Metadata.Annotations["JobID"] != "" {
+		delete(arraySubjobs, pod.Metadata.Uid)
+		return "", false
+	}
+	return subjob, ok
+}
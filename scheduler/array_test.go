@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+// addArrayPod adds a pending pod of the "batch" array label group.
+func (c *testCluster) addArrayPod(name string, value string, cpu string, created string) {
+	pod := testPod(name, cpu, "100Mi")
+	pod.Metadata.Labels = map[string]string{"batch": value}
+	pod.Metadata.CreationTimestamp = created
+	c.api.AddPod("default", pod)
+}
+
+func TestArraySubmission(t *testing.T) {
+	c := newTestCluster(t, "node1")
+	config.Arrays.Label = "batch"
+	c.addArrayPod("a0", "sweep", "1", "2026-01-01T00:00:00Z")
+	c.addArrayPod("a1", "sweep", "1", "2026-01-01T00:00:01Z")
+	c.addArrayPod("a2", "sweep", "1", "2026-01-01T00:00:02Z")
+	c.addArrayPod("big", "sweep", "2", "2026-01-01T00:00:03Z")
+
+	before := len(c.api.Requests())
+	pod := c.pod(t, "a0")
+	err := schedulePod(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeLists := 0
+	for _, r := range c.api.Requests()[before:] {
+		if r.Method == "GET" && r.Path == "/api/v1/nodes" {
+			nodeLists++
+		}
+	}
+	if nodeLists != 1 {
+		t.Errorf("nodes listed %d times for one array, want once", nodeLists)
+	}
+	jobs := map[string]string{}
+	for _, name := range []string{"a0", "a1", "a2"} {
+		p := c.pod(t, name)
+		jobs[name] = p.Metadata.Annotations["JobID"]
+		if p.Metadata.Labels[arrayJobLabel] != "1" {
+			t.Errorf("pod %s %s label = %q, want 1", name, arrayJobLabel, p.Metadata.Labels[arrayJobLabel])
+		}
+	}
+	want := map[string]string{"a0": "1[0].fakepbs", "a1": "1[1].fakepbs", "a2": "1[2].fakepbs"}
+	for name, jobid := range want {
+		if jobs[name] != jobid {
+			t.Errorf("pod %s job = %q, want %s", name, jobs[name], jobid)
+		}
+	}
+	if jobid := c.pod(t, "big").Metadata.Annotations["JobID"]; jobid != "" {
+		t.Errorf("pod requesting other resources joined the array as %s", jobid)
+	}
+}
+
+func TestArraysOfOneGroupAreLabelledApart(t *testing.T) {
+	c := newTestCluster(t, "node1")
+	config.Arrays.Label = "batch"
+	c.addArrayPod("a0", "sweep", "1", "2026-01-01T00:00:00Z")
+	c.addArrayPod("a1", "sweep", "1", "2026-01-01T00:00:01Z")
+	pod := c.pod(t, "a0")
+	err := schedulePod(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.addArrayPod("b0", "sweep", "1", "2026-01-01T00:01:00Z")
+	c.addArrayPod("b1", "sweep", "1", "2026-01-01T00:01:01Z")
+	pod = c.pod(t, "b0")
+	err = schedulePod(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, second := c.pod(t, "a0").Metadata.Labels, c.pod(t, "b0").Metadata.Labels
+	if first[arrayIndexLabel] != second[arrayIndexLabel] {
+		t.Fatalf("indices %s and %s, want both arrays numbered from 0", first[arrayIndexLabel], second[arrayIndexLabel])
+	}
+	if first[arrayJobLabel] == second[arrayJobLabel] {
+		t.Errorf("both arrays labelled %s=%s", arrayJobLabel, first[arrayJobLabel])
+	}
+}
+
+func TestJobRequestSame(t *testing.T) {
+	base := func() *jobRequest {
+		return &jobRequest{ncpus: 1, memMB: 100, policy: &NamespacePolicy{Queue: "workq"}, template: &PBSJobTemplateSpec{}}
+	}
+	if !base().same(base()) {
+		t.Error("identical requests differ")
+	}
+	changes := map[string]func(r *jobRequest){
+		"cpus":        func(r *jobRequest) { r.ncpus = 2 },
+		"constraints": func(r *jobRequest) { r.constraints = ":host=node1" },
+		"queue":       func(r *jobRequest) { r.policy.Queue = "express" },
+		"template":    func(r *jobRequest) { r.template.Walltime = "01:00:00" },
+		"priority":    func(r *jobRequest) { r.priority = []string{"-p", "10"} },
+		"user":        func(r *jobRequest) { r.user = "alice" },
+	}
+	for name, change := range changes {
+		r := base()
+		change(r)
+		if base().same(r) {
+			t.Errorf("requests differing by %s are the same", name)
+		}
+	}
+}
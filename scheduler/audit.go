@@ -0,0 +1,351 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Outcomes of the scheduling decisions.
+const (
+	outcomeBound      = "bound"
+	outcomeBindFailed = "bind failed"
+	outcomeSubmitted  = "submitted"
+	outcomePending    = "pending"
+	outcomeRejected   = "rejected"
+	outcomeError      = "error"
+)
+
+// AuditRecord is a scheduling decision about a pod, as written to the audit
+// log.
+type AuditRecord struct {
+	Time      string `json:"time"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	UID       string `json:"uid"`
+	// NCPUs and MemMB are the resources computed for a pod submitted by
+	// this decision.
+	NCPUs int `json:"ncpus,omitempty"`
+	MemMB int `json:"memMB,omitempty"`
+	// Command is the qsub command line, with sudo for jobs submitted on
+	// behalf of their owner.
+	Command []string `json:"command,omitempty"`
+	JobID   string   `json:"jobId,omitempty"`
+	// Snapshot is the job status findnode looked for the node in.
+	Snapshot *JobStatus `json:"snapshot,omitempty"`
+	Node     string     `json:"node,omitempty"`
+	Outcome  string     `json:"outcome"`
+	Error    string     `json:"error,omitempty"`
+	DryRun   bool       `json:"dryRun,omitempty"`
+}
+
+// AuditConfig enables the audit log.
+type AuditConfig struct {
+	// Path is the audit log file, rotated to Path.1, Path.2...
+	Path string `json:"path"`
+	// MaxSizeMB is the size the file is rotated at, 100 by default.
+	MaxSizeMB int `json:"max_size_mb"`
+	// MaxFiles is the number of rotated files kept, 5 by default.
+	MaxFiles int `json:"max_files"`
+}
+
+func (c *AuditConfig) validate() error {
+	if c.MaxSizeMB < 0 || c.MaxFiles < 0 {
+		return fmt.Errorf("max_size_mb and max_files cannot be negative")
+	}
+	return nil
+}
+
+func (c *AuditConfig) maxSize() int64 {
+	if c.MaxSizeMB == 0 {
+		return 100 << 20
+	}
+	return int64(c.MaxSizeMB) << 20
+}
+
+func (c *AuditConfig) maxFiles() int {
+	if c.MaxFiles == 0 {
+		return 5
+	}
+	return c.MaxFiles
+}
+
+// files returns the audit log files, oldest first.
+func (c *AuditConfig) files() []string {
+	var files []string
+	for i := c.maxFiles(); i >= 1; i-- {
+		files = append(files, c.Path+"."+strconv.Itoa(i))
+	}
+	return append(files, c.Path)
+}
+
+// auditWriter appends the records to the audit log, rotating it when it
+// reaches its maximum size.
+type auditWriter struct {
+	mu     sync.Mutex
+	config AuditConfig
+	file   *os.File
+	size   int64
+	// pending holds the last pending decision written for each pod, by
+	// UID, so that passes leaving a pod pending for the same reason are
+	// only audited once.
+	pending map[string]string
+}
+
+// auditLog is the audit log of the scheduler, nil when it is disabled.
+var auditLog *auditWriter
+
+// decision is the scheduling decision in progress, nil outside one or when
+// the audit log is disabled. It is only used with processLock held.
+var decision *AuditRecord
+
+// openAuditLog opens the audit log for appending.
+func openAuditLog(c AuditConfig) (*auditWriter, error) {
+	a := &auditWriter{config: c, pending: map[string]string{}}
+	err := a.open()
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditWriter) open() error {
+	f, err := os.OpenFile(a.config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.file, a.size = f, info.Size()
+	return nil
+}
+
+// rotate renames the file to Path.1, shifting the older files and dropping
+// the oldest, and starts a new file.
+func (a *auditWriter) rotate() error {
+	err := a.file.Close()
+	if err != nil {
+		return err
+	}
+	files := a.config.files()
+	os.Remove(files[0])
+	for i := 1; i < len(files); i++ {
+		err = os.Rename(files[i], files[i-1])
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return a.open()
+}
+
+// write appends a record to the audit log.
+func (a *auditWriter) write(r *AuditRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.size > 0 && a.size+int64(len(line)) > a.config.maxSize() {
+		err = a.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+// prune forgets the pending decisions of the pods missing from pods, the
+// pods of the scheduler.
+func (a *auditWriter) prune(pods []Pod) {
+	if a == nil {
+		return
+	}
+	live := map[string]bool{}
+	for i := range pods {
+		live[pods[i].Metadata.Uid] = true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for uid := range a.pending {
+		if !live[uid] {
+			delete(a.pending, uid)
+		}
+	}
+}
+
+// beginDecision starts auditing a scheduling decision about the pod.
+func beginDecision(pod *Pod) {
+	if auditLog == nil {
+		decision = nil
+		return
+	}
+	decision = &AuditRecord{
+		Namespace: namespaceOf(pod),
+		Pod:       pod.Metadata.Name,
+		UID:       pod.Metadata.Uid,
+		DryRun:    dryRun,
+	}
+}
+
+// resources records the resources computed for the pod.
+func (r *AuditRecord) resources(ncpus int, memMB int) {
+	if r != nil {
+		r.NCPUs, r.MemMB = ncpus, memMB
+	}
+}
+
+// submission records the qsub command submitting the pod.
+func (r *AuditRecord) submission(sub Submission) {
+	if r != nil {
+		name, args := sub.command()
+		r.Command = append([]string{name}, args...)
+	}
+}
+
+// job records the PBS job of the pod, before its JobID annotation is set.
+func (r *AuditRecord) job(jobid string) {
+	if r != nil {
+		r.JobID = jobid
+	}
+}
+
+// snapshot records the job status the node was looked for in.
+func (r *AuditRecord) snapshot(status *JobStatus) {
+	if r != nil && status != nil {
+		s := *status
+		r.Snapshot = &s
+	}
+}
+
+// endDecision writes the decision about the pod to the audit log: the node
+// it was bound to, if any, and the error ending the decision.
+func endDecision(pod *Pod, node string, err error) {
+	r := decision
+	decision = nil
+	if r == nil {
+		return
+	}
+	r.Time = time.Now().UTC().Format(time.RFC3339)
+	if jobid := pod.Metadata.Annotations["JobID"]; jobid != "" {
+		r.JobID = jobid
+	}
+	r.Node = node
+	if err != nil {
+		r.Error = err.Error()
+	}
+	_, rejected := err.(*rejection)
+	switch {
+	case rejected:
+		r.Outcome = outcomeRejected
+	case node != "" && err != nil:
+		r.Outcome = outcomeBindFailed
+	case err != nil:
+		r.Outcome = outcomeError
+	case node != "":
+		r.Outcome = outcomeBound
+	case r.Command != nil:
+		r.Outcome = outcomeSubmitted
+	default:
+		r.Outcome = outcomePending
+	}
+
+	auditLog.mu.Lock()
+	if r.Outcome == outcomePending {
+		key := r.JobID
+		if r.Snapshot != nil {
+			key += "\x00" + r.Snapshot.State + "\x00" + r.Snapshot.Substate + "\x00" + r.Snapshot.Comment
+		}
+		if last, ok := auditLog.pending[r.UID]; ok && last == key {
+			auditLog.mu.Unlock()
+			return
+		}
+		auditLog.pending[r.UID] = key
+	} else {
+		delete(auditLog.pending, r.UID)
+	}
+	auditLog.mu.Unlock()
+
+	err = auditLog.write(r)
+	if err != nil {
+		log.Printf("Audit log: %v", err)
+	}
+}
+
+// readAudit calls fn with the records of the audit log, oldest first.
+func readAudit(c AuditConfig, fn func(r *AuditRecord)) error {
+	for _, path := range c.files() {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16<<20)
+		for scanner.Scan() {
+			var r AuditRecord
+			if json.Unmarshal(scanner.Bytes(), &r) != nil {
+				continue
+			}
+			fn(&r)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
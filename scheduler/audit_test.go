@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// testAuditLog opens an audit log in a temporary directory as the audit log
+// of the scheduler until the test ends.
+func testAuditLog(t *testing.T, c AuditConfig) *auditWriter {
+	c.Path = t.TempDir() + "/audit.jsonl"
+	a, err := openAuditLog(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := auditLog
+	auditLog = a
+	t.Cleanup(func() {
+		auditLog = saved
+		a.file.Close()
+	})
+	return a
+}
+
+func TestAuditRotation(t *testing.T) {
+	a := testAuditLog(t, AuditConfig{MaxSizeMB: 1, MaxFiles: 2})
+	big := strings.Repeat("x", 300<<10)
+	for i := 0; i < 10; i++ {
+		err := a.write(&AuditRecord{Pod: "web", Outcome: outcomeError, Error: big})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range a.config.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > a.config.maxSize() {
+			t.Errorf("%s is %d bytes, above the maximum size", path, info.Size())
+		}
+	}
+	_, err := os.Stat(a.config.Path + ".3")
+	if !os.IsNotExist(err) {
+		t.Errorf("%s.3 kept beyond max_files", a.config.Path)
+	}
+	count := 0
+	err = readAudit(a.config, func(r *AuditRecord) { count++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 7 {
+		t.Errorf("read %d records, want the 7 of the last 3 files", count)
+	}
+}
+
+func TestAuditPendingDecisions(t *testing.T) {
+	a := testAuditLog(t, AuditConfig{})
+	pod := &Pod{Metadata: Metadata{Name: "web", Uid: "web-uid", Annotations: map[string]string{"JobID": "12.server"}}}
+	for i := 0; i < 3; i++ {
+		beginDecision(pod)
+		decision.snapshot(&JobStatus{State: "Q", Comment: "Not Running: Insufficient amount of resource: ncpus"})
+		endDecision(pod, "", nil)
+	}
+	a.prune(nil)
+	beginDecision(pod)
+	decision.snapshot(&JobStatus{State: "Q", Comment: "Not Running: Insufficient amount of resource: ncpus"})
+	endDecision(pod, "", nil)
+
+	var records []*AuditRecord
+	err := readAudit(a.config, func(r *AuditRecord) { records = append(records, r) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("%d records, want one before and one after the pod is forgotten", len(records))
+	}
+	if records[0].Outcome != outcomePending || records[0].JobID != "12.server" {
+		t.Errorf("record %+v", records[0])
+	}
+}
+
+func TestAuditJobOfArraySubmission(t *testing.T) {
+	a := testAuditLog(t, AuditConfig{})
+	pod := &Pod{Metadata: Metadata{Name: "a0", Uid: "a0-uid"}}
+	beginDecision(pod)
+	decision.submission(Submission{Args: []string{"-J", "0-2"}})
+	decision.job("7[0].server")
+	endDecision(pod, "", nil)
+
+	var records []*AuditRecord
+	err := readAudit(a.config, func(r *AuditRecord) { records = append(records, r) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Outcome != outcomeSubmitted || records[0].JobID != "7[0].server" {
+		t.Errorf("records %+v, want the submission of 7[0].server", records)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testCluster is the fake API server and PBS server the scheduler talks to
+// during a test.
+type testCluster struct {
+	api *FakeAPIServer
+	pbs *FakePBS
+}
+
+// newTestCluster points the scheduler at a fake API server and PBS server
+// with the given nodes of 4 cpus and 8GB, and restores the scheduler state
+// when the test ends.
+func newTestCluster(t *testing.T, nodes ...string) *testCluster {
+	c := &testCluster{api: NewFakeAPIServer(), pbs: NewFakePBS()}
+	for _, name := range nodes {
+		c.api.AddNode(Node{Metadata: Metadata{Name: name, Labels: map[string]string{hostnameLabel: name}}})
+		c.pbs.AddNode(&FakePBSNode{Name: name, Ncpus: 4, MemMB: 8192})
+	}
+
+	savedConfig, savedHost, savedPBS, savedDelay := config, apiHost, pbsServer, submitDelay
+	savedRecorder, savedStore, savedAudit := recorder, stateStore, auditLog
+	savedSubjobs, savedActive := arraySubjobs, activePods
+	t.Cleanup(func() {
+		config, apiHost, pbsServer, submitDelay = savedConfig, savedHost, savedPBS, savedDelay
+		recorder, stateStore, auditLog = savedRecorder, savedStore, savedAudit
+		arraySubjobs, activePods = savedSubjobs, savedActive
+		c.api.Close()
+	})
+	config = &Config{}
+	apiHost = c.api.Host()
+	pbsServer = c.pbs
+	submitDelay = 0
+	recorder = newEventRecorder(coreEventSink{}, 0)
+	stateStore = annotationStore{}
+	auditLog = nil
+	arraySubjobs = map[string]string{}
+	activePods = nil
+	return c
+}
+
+// testPod returns a pending pod selecting the scheduler with one container
+// requesting the cpus and memory.
+func testPod(name string, cpu string, memory string) Pod {
+	return Pod{
+		Metadata: Metadata{Name: name, Uid: name + "-uid"},
+		Spec: PodSpec{
+			SchedulerName: schedulerName(),
+			Containers: []Container{{
+				Name:      "main",
+				Resources: ResourceRequirements{Requests: ResourceList{"cpu": cpu, "memory": memory}},
+			}},
+		},
+	}
+}
+
+// pod returns the pod as stored by the fake API server.
+func (c *testCluster) pod(t *testing.T, name string) Pod {
+	t.Helper()
+	pod, ok := c.api.Pod("default", name)
+	if !ok {
+		t.Fatalf("pod %s not found", name)
+	}
+	return pod
+}
+
+// eventually polls cond until it holds or the timeout expires.
+func eventually(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Config holds the scheduler settings read from the JSON file given with
+// -config. Every setting is optional.
+type Config struct {
+	// PriorityClasses maps pod priorities onto PBS job priority and queue.
+	PriorityClasses []PriorityMapping `json:"priority_classes"`
+
+	// TaintResources lists the taint keys the site defined PBS boolean
+	// resources for. Pods not tolerating them request <resource>=False.
+	TaintResources []string `json:"taint_resources"`
+
+	// EventAPI selects the events API: "auto", "v1" or "events.k8s.io/v1".
+	EventAPI string `json:"event_api"`
+	// Instance names this scheduler instance in events, by default the
+	// host name.
+	Instance string `json:"instance"`
+
+	// JobScript selects the script of the PBS jobs backing the pods.
+	JobScript JobScriptConfig `json:"job_script"`
+
+	// Identity maps pods to the PBS user their jobs run as.
+	Identity IdentityConfig `json:"identity"`
+
+	// Policy locates the per namespace PBS settings and limits.
+	Policy PolicyConfig `json:"namespace_policy"`
+
+	// JobTemplates restricts the options PBSJobTemplates may set.
+	JobTemplates JobTemplateConfig `json:"job_templates"`
+
+	// Arrays selects the pods submitted together as PBS job arrays.
+	Arrays JobArrayConfig `json:"job_arrays"`
+
+	// StatusInterval is the number of seconds a qstat of all the jobs is
+	// reused for job status lookups, 10 by default. A negative value runs
+	// qstat for each lookup.
+	StatusInterval int `json:"status_interval"`
+
+	// Notify enables the endpoint PBS hooks notify when a job starts.
+	Notify NotifyConfig `json:"notify"`
+
+	// Webhook enables the admission webhooks.
+	Webhook WebhookConfig `json:"webhook"`
+
+	// SchedulerName is the name pods use to select this scheduler,
+	// "pbs-scheduler" by default.
+	SchedulerName string `json:"scheduler_name"`
+
+	// StateStore selects where the PBS jobs of the pods are recorded
+	// besides the pod annotations.
+	StateStore StateStoreConfig `json:"state_store"`
+
+	// Audit enables the audit log of the scheduling decisions.
+	Audit AuditConfig `json:"audit"`
+}
+
+var config = &Config{}
+
+// loadConfig reads and validates the scheduler config file.
+func loadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := &Config{}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Config: %s: %v", path, err)
+	}
+	err = cfg.validate()
+	if err != nil {
+		return nil, fmt.Errorf("Config: %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	switch c.EventAPI {
+	case "", "auto", "v1", "events.k8s.io/v1":
+	default:
+		return fmt.Errorf("event_api: unknown events API %q", c.EventAPI)
+	}
+	err := c.JobScript.load()
+	if err != nil {
+		return fmt.Errorf("job_script: %v", err)
+	}
+	err = c.Identity.validate()
+	if err != nil {
+		return fmt.Errorf("identity: %v", err)
+	}
+	err = c.Notify.validate()
+	if err != nil {
+		return fmt.Errorf("notify: %v", err)
+	}
+	err = c.Webhook.validate()
+	if err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+	err = c.StateStore.validate()
+	if err != nil {
+		return fmt.Errorf("state_store: %v", err)
+	}
+	err = c.Audit.validate()
+	if err != nil {
+		return fmt.Errorf("audit: %v", err)
+	}
+	for i, m := range c.PriorityClasses {
+		if m.PriorityClassName == "" && m.MinPriority == nil {
+			return fmt.Errorf("priority_classes[%d]: one of priority_class_name or min_priority is required", i)
+		}
+		if m.PBSPriority != nil && (*m.PBSPriority < -1024 || *m.PBSPriority > 1023) {
+			return fmt.Errorf("priority_classes[%d]: pbs_priority %d is outside -1024..1023", i, *m.PBSPriority)
+		}
+	}
+	return nil
+}
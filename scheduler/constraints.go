@@ -0,0 +1,237 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// hostnameLabel is the well known node label holding the node's hostname,
+// which PBS exposes as the built-in "host" resource.
+const hostnameLabel = "kubernetes.io/hostname"
+
+// placement translates the node constraints of a pod (nodeSelector, required
+// node affinity and the taints it does not tolerate) into the extra
+// resources of a PBS select chunk, e.g. ":host=node001:ssd=True".
+//
+// Labels other than the hostname are mapped onto custom boolean resources
+// named after the label (or taint) key, so sites must define a matching
+// boolean resource on their vnodes. Only the taints listed in the
+// taint_resources setting are translated, other taints are left to PBS. An
+// error is returned for any constraint PBS cannot express.
+func placement(pod *Pod, nodes []Node) (string, error) {
+	resources := map[string]string{}
+
+	keys := make([]string, 0, len(pod.Spec.NodeSelector))
+	for key := range pod.Spec.NodeSelector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		err := labelConstraint(resources, key, pod.Spec.NodeSelector[key])
+		if err != nil {
+			return "", fmt.Errorf("nodeSelector %s: %v", key, err)
+		}
+	}
+
+	err := affinityConstraints(resources, pod.Spec.Affinity)
+	if err != nil {
+		return "", err
+	}
+
+	for _, node := range nodes {
+		for _, taint := range node.Spec.Taints {
+			if taint.Effect != "NoSchedule" && taint.Effect != "NoExecute" {
+				continue
+			}
+			if !taintResource(taint.Key) {
+				continue
+			}
+			if tolerates(pod.Spec.Tolerations, taint) {
+				continue
+			}
+			name, err := resourceName(taint.Key)
+			if err != nil {
+				return "", fmt.Errorf("taint %s: %v", taint.Key, err)
+			}
+			err = setResource(resources, name, "False")
+			if err != nil {
+				return "", fmt.Errorf("taint %s: %v", taint.Key, err)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	chunk := ""
+	for _, name := range names {
+		chunk += ":" + name + "=" + resources[name]
+	}
+	return chunk, nil
+}
+
+// taintResource reports whether the site defined a PBS boolean resource for
+// the taint key.
+func taintResource(key string) bool {
+	for _, k := range config.TaintResources {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func affinityConstraints(resources map[string]string, affinity *Affinity) error {
+	if affinity == nil || affinity.NodeAffinity == nil {
+		return nil
+	}
+	required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil || len(required.NodeSelectorTerms) == 0 {
+		return nil
+	}
+	// PBS has no way to OR several alternatives inside one select chunk.
+	if len(required.NodeSelectorTerms) > 1 {
+		return fmt.Errorf("node affinity: %d nodeSelectorTerms cannot be expressed in PBS, only one is supported",
+			len(required.NodeSelectorTerms))
+	}
+	term := required.NodeSelectorTerms[0]
+
+	for _, field := range term.MatchFields {
+		if field.Key != "metadata.name" || field.Operator != "In" || len(field.Values) != 1 {
+			return fmt.Errorf("node affinity: field %s %s %v cannot be expressed in PBS",
+				field.Key, field.Operator, field.Values)
+		}
+		err := setResource(resources, "host", field.Values[0])
+		if err != nil {
+			return fmt.Errorf("node affinity: %v", err)
+		}
+	}
+
+	for _, expr := range term.MatchExpressions {
+		var err error
+		switch {
+		case expr.Operator == "In" && len(expr.Values) == 1:
+			err = labelConstraint(resources, expr.Key, expr.Values[0])
+		case expr.Operator == "Exists" && expr.Key != hostnameLabel:
+			err = labelConstraint(resources, expr.Key, "True")
+		case expr.Operator == "DoesNotExist" && expr.Key != hostnameLabel:
+			err = labelConstraint(resources, expr.Key, "False")
+		default:
+			err = fmt.Errorf("operator %s with values %v cannot be expressed in PBS", expr.Operator, expr.Values)
+		}
+		if err != nil {
+			return fmt.Errorf("node affinity %s: %v", expr.Key, err)
+		}
+	}
+	return nil
+}
+
+// labelConstraint records the PBS resource matching a required node label.
+func labelConstraint(resources map[string]string, key string, value string) error {
+	if key == hostnameLabel {
+		return setResource(resources, "host", value)
+	}
+	var boolean string
+	switch strings.ToLower(value) {
+	case "true":
+		boolean = "True"
+	case "false":
+		boolean = "False"
+	default:
+		return fmt.Errorf("value %q is not a boolean and cannot be expressed in PBS", value)
+	}
+	name, err := resourceName(key)
+	if err != nil {
+		return err
+	}
+	return setResource(resources, name, boolean)
+}
+
+func setResource(resources map[string]string, name string, value string) error {
+	if current, ok := resources[name]; ok && current != value {
+		return fmt.Errorf("conflicting requirements %s=%s and %s=%s", name, current, name, value)
+	}
+	resources[name] = value
+	return nil
+}
+
+// resourceName derives a PBS resource name from a label or taint key by
+// dropping its prefix and replacing dots, e.g. "example.com/fast.disk"
+// becomes "fast_disk".
+func resourceName(key string) (string, error) {
+	name := key[strings.LastIndex(key, "/")+1:]
+	name = strings.Replace(name, ".", "_", -1)
+	if name == "" {
+		return "", fmt.Errorf("empty resource name")
+	}
+	for i, r := range name {
+		alpha := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if i == 0 && !alpha {
+			return "", fmt.Errorf("%q is not a valid PBS resource name", name)
+		}
+		if !alpha && !(r >= '0' && r <= '9') && r != '_' && r != '-' {
+			return "", fmt.Errorf("%q is not a valid PBS resource name", name)
+		}
+	}
+	return name, nil
+}
+
+// tolerates reports whether any of the tolerations matches the taint.
+func tolerates(tolerations []Toleration, taint Taint) bool {
+	for _, t := range tolerations {
+		if t.Effect != "" && t.Effect != taint.Effect {
+			continue
+		}
+		if t.Key == "" && t.Operator == "Exists" {
+			return true
+		}
+		if t.Key != taint.Key {
+			continue
+		}
+		if t.Operator == "Exists" || t.Value == taint.Value {
+			return true
+		}
+	}
+	return false
+}
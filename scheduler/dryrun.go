@@ -0,0 +1,95 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// dryRun makes the scheduler log the qsub commands, bindings, evictions,
+// pod patches and events it would issue instead of performing them.
+var dryRun = false
+
+// Kinds of actions skipped in dry run mode, in summary order.
+var dryRunKinds = []string{"qsub", "qdel", "binding", "eviction", "pod patch", "event"}
+
+// dryRunSummary counts the actions skipped in dry run mode during one
+// scheduling iteration.
+type dryRunSummary struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+var dryRunStats = &dryRunSummary{counts: map[string]int{}}
+
+// skip logs an action of the given kind skipped in dry run mode.
+func (s *dryRunSummary) skip(kind string, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[kind]++
+	log.Println("Dry run: " + msg)
+}
+
+// flush logs the summary of the iteration and resets the counters.
+func (s *dryRunSummary) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var parts []string
+	for _, kind := range dryRunKinds {
+		parts = append(parts, fmt.Sprintf("%d %s", s.counts[kind], kind))
+	}
+	log.Println("Dry run summary, skipped: " + strings.Join(parts, ", "))
+	s.counts = map[string]int{}
+}
+
+// commandLine formats a command and its arguments as a shell would accept
+// them.
+func commandLine(name string, args []string) string {
+	quoted := []string{name}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`|&;<>()*?[]{}!#~") {
+			arg = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+		quoted = append(quoted, arg)
+	}
+	return strings.Join(quoted, " ")
+}
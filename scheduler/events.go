@@ -0,0 +1,307 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var errEventNotFound = errors.New("Event: not found")
+
+// microTime is the layout of the MicroTime fields of events.k8s.io/v1.
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// eventActions gives the action taken by the scheduler for each event reason.
+var eventActions = map[string]string{
+	"FailedScheduling": "Scheduling",
+	"Scheduled":        "Binding",
+	"Preempted":        "Preempting",
+}
+
+// instanceName identifies this scheduler instance in the events it reports:
+// the configured instance name, or the host name.
+func instanceName() string {
+	if config.Instance != "" {
+		return config.Instance
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
+
+// EventSink stores the events of the recorder in the API server.
+type EventSink interface {
+	// Create posts a new event and returns its name.
+	Create(event Event) (string, error)
+	// Update records that the event of the namespace occurred count times,
+	// the last time at last with the given message.
+	Update(namespace string, name string, count int64, last time.Time, msg string) error
+	// MutableMessage reports whether Update can change the message of an
+	// existing event.
+	MutableMessage() bool
+}
+
+// coreEventSink stores core/v1 events.
+type coreEventSink struct{}
+
+func (coreEventSink) Create(event Event) (string, error) {
+	created, err := postsEvent(event)
+	if err != nil {
+		return "", err
+	}
+	return created.Metadata.Name, nil
+}
+
+func (coreEventSink) Update(namespace string, name string, count int64, last time.Time, msg string) error {
+	patch := map[string]interface{}{
+		"count":         count,
+		"lastTimestamp": last.UTC().Format(time.RFC3339),
+		"message":       msg,
+	}
+	return patchEvent(eventEndpoint, namespace, name, patch)
+}
+
+func (coreEventSink) MutableMessage() bool { return true }
+
+// eventsV1Sink stores events.k8s.io/v1 events, reporting repeats as an
+// event series. Only the series of such an event may change after its
+// creation, so its note is never patched.
+type eventsV1Sink struct{}
+
+func (eventsV1Sink) Create(event Event) (string, error) {
+	created, err := postsEventV1(EventV1{
+		ApiVersion:          "events.k8s.io/v1",
+		Kind:                "Event",
+		Metadata:            Metadata{GenerateName: event.Metadata.GenerateName, Namespace: event.Metadata.Namespace},
+		EventTime:           time.Now().UTC().Format(microTime),
+		ReportingController: event.Source.Component,
+		ReportingInstance:   event.Source.Component + "-" + event.Source.Host,
+		Action:              event.Action,
+		Reason:              event.Reason,
+		Regarding:           event.InvolvedObject,
+		Note:                event.Message,
+		Type:                event.Type,
+	})
+	if err != nil {
+		return "", err
+	}
+	return created.Metadata.Name, nil
+}
+
+func (eventsV1Sink) Update(namespace string, name string, count int64, last time.Time, msg string) error {
+	patch := map[string]interface{}{
+		"series": EventSeries{
+			Count:            count,
+			LastObservedTime: last.UTC().Format(microTime),
+		},
+	}
+	return patchEvent(eventV1Endpoint, namespace, name, patch)
+}
+
+func (eventsV1Sink) MutableMessage() bool { return false }
+
+// newEventSink returns the sink for the configured events API: "v1",
+// "events.k8s.io/v1", or "auto" (the default) which prefers
+// events.k8s.io/v1 when the cluster serves it and falls back to core/v1.
+func newEventSink(api string) (EventSink, error) {
+	switch api {
+	case "v1":
+		return coreEventSink{}, nil
+	case "events.k8s.io/v1":
+		return eventsV1Sink{}, nil
+	case "", "auto":
+		available, err := apiAvailable(eventsV1Group)
+		if err != nil {
+			log.Println(err)
+		}
+		if available {
+			return eventsV1Sink{}, nil
+		}
+		return coreEventSink{}, nil
+	}
+	return nil, fmt.Errorf("Event: unknown events API %q", api)
+}
+
+// eventTTL is how long the API server keeps events by default; older events
+// are not worth patching.
+const eventTTL = time.Hour
+
+// eventKey identifies the events aggregated together.
+type eventKey struct {
+	uid    string
+	reason string
+}
+
+type recordedEvent struct {
+	namespace string
+	name      string
+	count     int64
+	message   string
+	last      time.Time
+	posted    time.Time
+	// postedCount is the count last sent to the API server.
+	postedCount int64
+}
+
+// eventRecorder posts pod events, aggregating repeats of the same reason
+// for the same pod into a single event whose count, lastTimestamp and
+// message are patched. Updates are rate limited to one per pod and reason
+// every interval; repeats in between are only counted. When the sink cannot
+// change the message of an event, a new message starts a new event.
+type eventRecorder struct {
+	mu       sync.Mutex
+	sink     EventSink
+	interval time.Duration
+	events   map[eventKey]*recordedEvent
+}
+
+var recorder = newEventRecorder(coreEventSink{}, time.Minute)
+
+func newEventRecorder(sink EventSink, interval time.Duration) *eventRecorder {
+	return &eventRecorder{
+		sink:     sink,
+		interval: interval,
+		events:   map[eventKey]*recordedEvent{},
+	}
+}
+
+// Record posts or updates the event about the pod.
+func (r *eventRecorder) Record(pod *Pod, reason string, eventType string, msg string) error {
+	if dryRun {
+		dryRunStats.skip("event", fmt.Sprintf("%s event on pod %s: %s", reason, pod.Metadata.Name, msg))
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.expire(now)
+
+	key := eventKey{uid: pod.Metadata.Uid, reason: reason}
+	rec, ok := r.events[key]
+	if ok && msg != rec.message && !r.sink.MutableMessage() {
+		// Post the repeats of the previous message before replacing its event.
+		if rec.count > rec.postedCount {
+			err := r.sink.Update(rec.namespace, rec.name, rec.count, rec.last, rec.message)
+			if err != nil && err != errEventNotFound {
+				return err
+			}
+		}
+		delete(r.events, key)
+		ok = false
+	}
+	if ok {
+		rec.count++
+		rec.message = msg
+		rec.last = now
+		if now.Sub(rec.posted) < r.interval {
+			return nil
+		}
+		err := r.sink.Update(rec.namespace, rec.name, rec.count, now, rec.message)
+		if err == nil {
+			rec.posted, rec.postedCount = now, rec.count
+			return nil
+		}
+		if err != errEventNotFound {
+			return err
+		}
+		// The event expired on the API server, start a new one.
+		delete(r.events, key)
+	}
+
+	name, err := r.sink.Create(newPodEvent(pod, reason, eventType, msg))
+	if err != nil {
+		return err
+	}
+	r.events[key] = &recordedEvent{
+		namespace:   namespaceOf(pod),
+		name:        name,
+		count:       1,
+		message:     msg,
+		last:        now,
+		posted:      now,
+		postedCount: 1,
+	}
+	return nil
+}
+
+// Flush posts the repeats counted since the last update of each event once
+// its interval has passed, so that the final count of a reason that stopped
+// recurring reaches the API server.
+func (r *eventRecorder) Flush() {
+	if dryRun {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.expire(now)
+	for key, rec := range r.events {
+		if rec.count == rec.postedCount || now.Sub(rec.posted) < r.interval {
+			continue
+		}
+		err := r.sink.Update(rec.namespace, rec.name, rec.count, rec.last, rec.message)
+		if err == errEventNotFound {
+			delete(r.events, key)
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		rec.posted, rec.postedCount = now, rec.count
+	}
+}
+
+// expire forgets events that the API server has dropped by now.
+func (r *eventRecorder) expire(now time.Time) {
+	for key, rec := range r.events {
+		if now.Sub(rec.posted) > eventTTL {
+			delete(r.events, key)
+		}
+	}
+}
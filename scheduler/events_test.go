@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// noteSink is an EventSink that, like the events.k8s.io/v1 API, rejects
+// updates that change the message of an event.
+type noteSink struct {
+	notes   map[string]string
+	counts  map[string]int64
+	created int
+}
+
+func (s *noteSink) Create(event Event) (string, error) {
+	s.created++
+	name := event.Metadata.GenerateName + string(rune('a'+s.created))
+	s.notes[name], s.counts[name] = event.Message, 1
+	return name, nil
+}
+
+func (s *noteSink) Update(namespace string, name string, count int64, last time.Time, msg string) error {
+	if msg != s.notes[name] {
+		return errors.New("Event: note is immutable")
+	}
+	s.counts[name] = count
+	return nil
+}
+
+func (s *noteSink) MutableMessage() bool { return false }
+
+func TestRecorderStartsNewEventOnNewMessage(t *testing.T) {
+	sink := &noteSink{notes: map[string]string{}, counts: map[string]int64{}}
+	r := newEventRecorder(sink, 0)
+	pod := testPod("p", "1", "100Mi")
+
+	for _, msg := range []string{"waiting", "waiting", "waiting", "queued"} {
+		if err := r.Record(&pod, "FailedScheduling", "Warning", msg); err != nil {
+			t.Fatalf("Record(%q): %v", msg, err)
+		}
+	}
+	if sink.created != 2 {
+		t.Fatalf("created %d events, want 2", sink.created)
+	}
+	for name, note := range sink.notes {
+		want := int64(1)
+		if note == "waiting" {
+			want = 3
+		}
+		if sink.counts[name] != want {
+			t.Errorf("event %q has count %d, want %d", note, sink.counts[name], want)
+		}
+	}
+}
@@ -0,0 +1,775 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeAPIServer is an in-memory Kubernetes API server serving the subset of
+// the API used by the scheduler: pods (list, watch, get, create, delete,
+// patch, binding, eviction and status), nodes, ConfigMaps, PBSJobTemplates
+// (get), and core/v1 and events.k8s.io/v1 events. It records every request and can inject errors
+// and watch disconnects. Point the scheduler at it by setting apiHost to
+// Host().
+type FakeAPIServer struct {
+	// EventsV1 makes the server advertise the events.k8s.io/v1 API.
+	EventsV1 bool
+
+	mu              sync.Mutex
+	server          *httptest.Server
+	resourceVersion int
+	pods            map[string]*Pod
+	nodes           []Node
+	configMaps      map[string]*ConfigMap
+	jobTemplates    map[string]*PBSJobTemplate
+	events          map[string]*Event
+	eventsV1        map[string]*EventV1
+	requests        []FakeRequest
+	faults          []*fakeFault
+	watchers        map[*fakeWatcher]bool
+}
+
+// FakeRequest is a request received by the FakeAPIServer.
+type FakeRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Body   []byte
+}
+
+type fakeFault struct {
+	method string
+	path   string
+	status int
+	times  int
+}
+
+type fakeWatcher struct {
+	selector string
+	events   chan PodWatchEvent
+	done     chan struct{}
+}
+
+// NewFakeAPIServer starts a fake API server on a local port.
+func NewFakeAPIServer() *FakeAPIServer {
+	f := &FakeAPIServer{
+		pods:         map[string]*Pod{},
+		configMaps:   map[string]*ConfigMap{},
+		jobTemplates: map[string]*PBSJobTemplate{},
+		events:       map[string]*Event{},
+		eventsV1:     map[string]*EventV1{},
+		watchers:     map[*fakeWatcher]bool{},
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+// Host returns the host:port the server listens on.
+func (f *FakeAPIServer) Host() string {
+	return strings.TrimPrefix(f.server.URL, "http://")
+}
+
+// Close disconnects the watches and stops the server.
+func (f *FakeAPIServer) Close() {
+	f.DisconnectWatches()
+	f.server.Close()
+}
+
+// AddPod stores a pod in the given namespace and notifies the watchers.
+func (f *FakeAPIServer) AddPod(namespace string, pod Pod) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addPod(namespace, pod)
+}
+
+// AddConfigMap stores a ConfigMap in the given namespace.
+func (f *FakeAPIServer) AddConfigMap(namespace string, cm ConfigMap) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cm.Metadata.Namespace = namespace
+	f.configMaps[namespace+"/"+cm.Metadata.Name] = &cm
+}
+
+// ConfigMap returns a copy of the named ConfigMap.
+func (f *FakeAPIServer) ConfigMap(namespace string, name string) (ConfigMap, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cm, ok := f.configMaps[namespace+"/"+name]
+	if !ok {
+		return ConfigMap{}, false
+	}
+	var c ConfigMap
+	b, _ := json.Marshal(cm)
+	json.Unmarshal(b, &c)
+	return c, true
+}
+
+// AddJobTemplate stores a PBSJobTemplate in the given namespace.
+func (f *FakeAPIServer) AddJobTemplate(namespace string, t PBSJobTemplate) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t.Metadata.Namespace = namespace
+	f.jobTemplates[namespace+"/"+t.Metadata.Name] = &t
+}
+
+// AddNode stores a node.
+func (f *FakeAPIServer) AddNode(node Node) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nodes = append(f.nodes, node)
+}
+
+// Pod returns a copy of the named pod.
+func (f *FakeAPIServer) Pod(namespace string, name string) (Pod, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pod, ok := f.pods[namespace+"/"+name]
+	if !ok {
+		return Pod{}, false
+	}
+	return copyPod(pod), true
+}
+
+// Pods returns a copy of all the pods.
+func (f *FakeAPIServer) Pods() []Pod {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var pods []Pod
+	for _, pod := range f.pods {
+		pods = append(pods, copyPod(pod))
+	}
+	return pods
+}
+
+// DeletePod removes a pod and notifies the watchers.
+func (f *FakeAPIServer) DeletePod(namespace string, name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.deletePod(namespace + "/" + name)
+}
+
+// Events returns the core/v1 events posted so far.
+func (f *FakeAPIServer) Events() []Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var events []Event
+	for _, event := range f.events {
+		events = append(events, *event)
+	}
+	return events
+}
+
+// EventsV1List returns the events.k8s.io/v1 events posted so far.
+func (f *FakeAPIServer) EventsV1List() []EventV1 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var events []EventV1
+	for _, event := range f.eventsV1 {
+		events = append(events, *event)
+	}
+	return events
+}
+
+// Requests returns the requests received so far.
+func (f *FakeAPIServer) Requests() []FakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeRequest(nil), f.requests...)
+}
+
+// InjectError makes the next times requests with the given method (any if
+// empty) and path prefix fail with the HTTP status, e.g. 409 or 500.
+func (f *FakeAPIServer) InjectError(method string, pathPrefix string, status int, times int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fakeFault{method: method, path: pathPrefix, status: status, times: times})
+}
+
+// DisconnectWatches ends every open watch, as the API server does when a
+// watch times out.
+func (f *FakeAPIServer) DisconnectWatches() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for w := range f.watchers {
+		close(w.done)
+		delete(f.watchers, w)
+	}
+}
+
+func (f *FakeAPIServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	f.mu.Lock()
+	f.requests = append(f.requests, FakeRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+		Body:   body,
+	})
+	status := f.fault(r.Method, r.URL.Path)
+	f.mu.Unlock()
+	if status != 0 {
+		writeStatus(w, status, "injected error")
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	selector := r.URL.Query().Get("fieldSelector")
+
+	switch {
+	case path == "/api/v1/watch/pods" && r.Method == http.MethodGet:
+		f.watch(w, selector)
+	case path == "/api/v1/pods" && r.Method == http.MethodGet:
+		f.listPods(w, selector)
+	case path == "/api/v1/nodes" && r.Method == http.MethodGet:
+		f.mu.Lock()
+		list := NodeList{ApiVersion: "v1", Kind: "NodeList", Items: append([]Node(nil), f.nodes...)}
+		f.mu.Unlock()
+		writeJSON(w, http.StatusOK, list)
+	case path == "/apis/events.k8s.io/v1" && r.Method == http.MethodGet:
+		if !f.EventsV1 {
+			writeStatus(w, http.StatusNotFound, "not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"kind": "APIResourceList", "groupVersion": "events.k8s.io/v1"})
+	case len(parts) >= 5 && parts[0] == "api" && parts[1] == "v1" && parts[2] == "namespaces" && parts[4] == "pods":
+		f.servePod(w, r, parts[3], parts[5:], body)
+	case len(parts) >= 5 && parts[0] == "api" && parts[1] == "v1" && parts[2] == "namespaces" && parts[4] == "configmaps":
+		f.serveConfigMap(w, r, parts[3], parts[5:], body)
+	case strings.Join(parts, "/") == "apis/pbs.altair.com/v1/pbsjobtemplates" && r.Method == http.MethodGet:
+		f.mu.Lock()
+		list := PBSJobTemplateList{Items: []PBSJobTemplate{}}
+		for _, t := range f.jobTemplates {
+			list.Items = append(list.Items, *t)
+		}
+		f.mu.Unlock()
+		writeJSON(w, http.StatusOK, list)
+	case len(parts) == 7 && strings.Join(parts[:3], "/") == "apis/pbs.altair.com/v1" && parts[3] == "namespaces" && parts[5] == "pbsjobtemplates" && r.Method == http.MethodGet:
+		f.mu.Lock()
+		t, ok := f.jobTemplates[parts[4]+"/"+parts[6]]
+		f.mu.Unlock()
+		if !ok {
+			writeStatus(w, http.StatusNotFound, "pbsjobtemplate not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, t)
+	case len(parts) >= 5 && parts[0] == "api" && parts[1] == "v1" && parts[2] == "namespaces" && parts[4] == "events":
+		f.serveEvent(w, r, parts[3], parts[5:], body, false)
+	case len(parts) >= 6 && strings.Join(parts[:3], "/") == "apis/events.k8s.io/v1" && parts[3] == "namespaces" && parts[5] == "events":
+		f.serveEvent(w, r, parts[4], parts[6:], body, true)
+	default:
+		writeStatus(w, http.StatusNotFound, "not found")
+	}
+}
+
+// fault returns the status of the first injected error matching the
+// request, or 0.
+func (f *FakeAPIServer) fault(method string, path string) int {
+	for i, fault := range f.faults {
+		if fault.method != "" && fault.method != method {
+			continue
+		}
+		if !strings.HasPrefix(path, fault.path) {
+			continue
+		}
+		fault.times--
+		if fault.times <= 0 {
+			f.faults = append(f.faults[:i], f.faults[i+1:]...)
+		}
+		return fault.status
+	}
+	return 0
+}
+
+func (f *FakeAPIServer) listPods(w http.ResponseWriter, selector string) {
+	f.mu.Lock()
+	list := PodList{ApiVersion: "v1", Kind: "PodList"}
+	keys := make([]string, 0, len(f.pods))
+	for key := range f.pods {
+		keys = append(keys, key)
+	}
+	// The API server lists objects ordered by namespace and name.
+	sort.Strings(keys)
+	for _, key := range keys {
+		if matchFieldSelector(f.pods[key], selector) {
+			list.Items = append(list.Items, copyPod(f.pods[key]))
+		}
+	}
+	list.Metadata.ResourceVersion = strconv.Itoa(f.resourceVersion)
+	f.mu.Unlock()
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (f *FakeAPIServer) watch(w http.ResponseWriter, selector string) {
+	watcher := &fakeWatcher{
+		selector: selector,
+		events:   make(chan PodWatchEvent, 1024),
+		done:     make(chan struct{}),
+	}
+	f.mu.Lock()
+	for _, pod := range f.pods {
+		if matchFieldSelector(pod, selector) {
+			watcher.events <- PodWatchEvent{Type: "ADDED", Object: copyPod(pod)}
+		}
+	}
+	f.watchers[watcher] = true
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case event := <-watcher.events:
+			if enc.Encode(event) != nil {
+				f.stopWatch(watcher)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-watcher.done:
+			return
+		}
+	}
+}
+
+func (f *FakeAPIServer) stopWatch(watcher *fakeWatcher) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.watchers[watcher] {
+		close(watcher.done)
+		delete(f.watchers, watcher)
+	}
+}
+
+func (f *FakeAPIServer) servePod(w http.ResponseWriter, r *http.Request, namespace string, rest []string, body []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(rest) == 0 {
+		if r.Method != http.MethodPost {
+			writeStatus(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var pod Pod
+		if err := json.Unmarshal(body, &pod); err != nil {
+			writeStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, ok := f.pods[namespace+"/"+pod.Metadata.Name]; ok {
+			writeStatus(w, http.StatusConflict, "pod already exists")
+			return
+		}
+		writeJSON(w, http.StatusCreated, f.addPod(namespace, pod))
+		return
+	}
+
+	key := namespace + "/" + rest[0]
+	pod, ok := f.pods[key]
+	if !ok {
+		writeStatus(w, http.StatusNotFound, "pod not found")
+		return
+	}
+	sub := ""
+	if len(rest) > 1 {
+		sub = rest[1]
+	}
+
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, copyPod(pod))
+	case sub == "" && r.Method == http.MethodDelete:
+		f.deletePod(key)
+		writeJSON(w, http.StatusOK, copyPod(pod))
+	case (sub == "" || sub == "status") && r.Method == http.MethodPatch:
+		var patch map[string]interface{}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			writeStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if sub == "" {
+			delete(patch, "status")
+		} else {
+			patch = map[string]interface{}{"status": patch["status"]}
+		}
+		updated := copyPod(pod)
+		if err := strategicMergePod(&updated, patch); err != nil {
+			writeStatus(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		f.updatePod(key, updated)
+		writeJSON(w, http.StatusOK, updated)
+	case sub == "binding" && r.Method == http.MethodPost:
+		var binding Binding
+		if err := json.Unmarshal(body, &binding); err != nil {
+			writeStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if pod.Spec.NodeName != "" {
+			writeStatus(w, http.StatusConflict, "pod is already assigned to node "+pod.Spec.NodeName)
+			return
+		}
+		updated := copyPod(pod)
+		updated.Spec.NodeName = binding.Target.Name
+		f.updatePod(key, updated)
+		writeJSON(w, http.StatusCreated, map[string]string{"kind": "Status", "status": "Success"})
+	case sub == "eviction" && r.Method == http.MethodPost:
+		f.deletePod(key)
+		writeJSON(w, http.StatusCreated, map[string]string{"kind": "Status", "status": "Success"})
+	default:
+		writeStatus(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (f *FakeAPIServer) serveEvent(w http.ResponseWriter, r *http.Request, namespace string, rest []string, body []byte, v1 bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodPost:
+		f.resourceVersion++
+		name := fmt.Sprintf("event-%d", f.resourceVersion)
+		if v1 {
+			var event EventV1
+			if err := json.Unmarshal(body, &event); err != nil {
+				writeStatus(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			event.Metadata.Name = event.Metadata.GenerateName + name
+			f.eventsV1[event.Metadata.Name] = &event
+			writeJSON(w, http.StatusCreated, event)
+			return
+		}
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			writeStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		event.Metadata.Name = event.Metadata.GenerateName + name
+		f.events[event.Metadata.Name] = &event
+		writeJSON(w, http.StatusCreated, event)
+	case len(rest) == 1 && r.Method == http.MethodPatch:
+		var patch map[string]interface{}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			writeStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var event interface{}
+		if e, ok := f.eventsV1[rest[0]]; ok && v1 {
+			event = e
+		} else if e, ok := f.events[rest[0]]; ok && !v1 {
+			event = e
+		} else {
+			writeStatus(w, http.StatusNotFound, "event not found")
+			return
+		}
+		if err := mergePatch(event, patch); err != nil {
+			writeStatus(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, event)
+	default:
+		writeStatus(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (f *FakeAPIServer) serveConfigMap(w http.ResponseWriter, r *http.Request, namespace string, rest []string, body []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodPost:
+		var cm ConfigMap
+		if err := json.Unmarshal(body, &cm); err != nil {
+			writeStatus(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		key := namespace + "/" + cm.Metadata.Name
+		if _, ok := f.configMaps[key]; ok {
+			writeStatus(w, http.StatusConflict, "configmap already exists")
+			return
+		}
+		f.resourceVersion++
+		cm.Metadata.Namespace = namespace
+		cm.Metadata.ResourceVersion = strconv.Itoa(f.resourceVersion)
+		f.configMaps[key] = &cm
+		writeJSON(w, http.StatusCreated, cm)
+	case len(rest) == 1:
+		key := namespace + "/" + rest[0]
+		cm, ok := f.configMaps[key]
+		if !ok {
+			writeStatus(w, http.StatusNotFound, "configmap not found")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, cm)
+		case http.MethodPut:
+			var updated ConfigMap
+			if err := json.Unmarshal(body, &updated); err != nil {
+				writeStatus(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			rv := updated.Metadata.ResourceVersion
+			if rv != "" && rv != cm.Metadata.ResourceVersion {
+				writeStatus(w, http.StatusConflict, "the object has been modified")
+				return
+			}
+			f.resourceVersion++
+			updated.Metadata.Namespace = namespace
+			updated.Metadata.ResourceVersion = strconv.Itoa(f.resourceVersion)
+			f.configMaps[key] = &updated
+			writeJSON(w, http.StatusOK, updated)
+		case http.MethodPatch:
+			var patch map[string]interface{}
+			if err := json.Unmarshal(body, &patch); err != nil {
+				writeStatus(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if err := mergePatch(cm, patch); err != nil {
+				writeStatus(w, http.StatusUnprocessableEntity, err.Error())
+				return
+			}
+			f.resourceVersion++
+			cm.Metadata.ResourceVersion = strconv.Itoa(f.resourceVersion)
+			writeJSON(w, http.StatusOK, cm)
+		case http.MethodDelete:
+			delete(f.configMaps, key)
+			writeJSON(w, http.StatusOK, cm)
+		default:
+			writeStatus(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	default:
+		writeStatus(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// addPod stores the pod; f.mu must be held.
+func (f *FakeAPIServer) addPod(namespace string, pod Pod) Pod {
+	f.resourceVersion++
+	pod.Metadata.Namespace = namespace
+	pod.Metadata.ResourceVersion = strconv.Itoa(f.resourceVersion)
+	if pod.Metadata.Uid == "" {
+		pod.Metadata.Uid = fmt.Sprintf("uid-%d", f.resourceVersion)
+	}
+	if pod.Metadata.CreationTimestamp == "" {
+		pod.Metadata.CreationTimestamp = time.Now().UTC().Format(time.RFC3339)
+	}
+	if pod.Status.Phase == "" {
+		pod.Status.Phase = "Pending"
+	}
+	key := namespace + "/" + pod.Metadata.Name
+	f.pods[key] = &pod
+	f.notify(nil, &pod)
+	return copyPod(&pod)
+}
+
+// updatePod replaces the pod; f.mu must be held.
+func (f *FakeAPIServer) updatePod(key string, pod Pod) {
+	f.resourceVersion++
+	pod.Metadata.ResourceVersion = strconv.Itoa(f.resourceVersion)
+	old := f.pods[key]
+	f.pods[key] = &pod
+	f.notify(old, &pod)
+}
+
+// deletePod removes the pod; f.mu must be held.
+func (f *FakeAPIServer) deletePod(key string) bool {
+	pod, ok := f.pods[key]
+	if !ok {
+		return false
+	}
+	delete(f.pods, key)
+	f.notify(pod, nil)
+	return true
+}
+
+// notify sends the watch event for a pod change to every watcher whose
+// field selector matches the old or the new pod; f.mu must be held.
+func (f *FakeAPIServer) notify(old *Pod, pod *Pod) {
+	for w := range f.watchers {
+		wasIn := old != nil && matchFieldSelector(old, w.selector)
+		isIn := pod != nil && matchFieldSelector(pod, w.selector)
+		var event PodWatchEvent
+		switch {
+		case !wasIn && isIn:
+			event = PodWatchEvent{Type: "ADDED", Object: copyPod(pod)}
+		case wasIn && isIn:
+			event = PodWatchEvent{Type: "MODIFIED", Object: copyPod(pod)}
+		case wasIn && !isIn:
+			event = PodWatchEvent{Type: "DELETED", Object: copyPod(old)}
+		default:
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			// A watcher too slow to keep up is disconnected.
+			close(w.done)
+			delete(f.watchers, w)
+		}
+	}
+}
+
+// matchFieldSelector supports the spec.nodeName and spec.schedulerName
+// selectors used by the scheduler.
+func matchFieldSelector(pod *Pod, selector string) bool {
+	if selector == "" {
+		return true
+	}
+	for _, term := range strings.Split(selector, ",") {
+		if strings.HasPrefix(term, "spec.nodeName!=") {
+			if pod.Spec.NodeName == strings.TrimPrefix(term, "spec.nodeName!=") {
+				return false
+			}
+		} else if strings.HasPrefix(term, "spec.nodeName=") {
+			if pod.Spec.NodeName != strings.TrimPrefix(term, "spec.nodeName=") {
+				return false
+			}
+		} else if strings.HasPrefix(term, "spec.schedulerName=") {
+			if pod.Spec.SchedulerName != strings.TrimPrefix(term, "spec.schedulerName=") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// strategicMergePod applies the strategic merge patch subset used by the
+// scheduler: a JSON merge patch where status.conditions are merged by type.
+func strategicMergePod(pod *Pod, patch map[string]interface{}) error {
+	var conditions []PodCondition
+	if status, ok := patch["status"].(map[string]interface{}); ok {
+		if raw, ok := status["conditions"]; ok {
+			b, _ := json.Marshal(raw)
+			if err := json.Unmarshal(b, &conditions); err != nil {
+				return err
+			}
+			delete(status, "conditions")
+		}
+	}
+	if err := mergePatch(pod, patch); err != nil {
+		return err
+	}
+	for _, c := range conditions {
+		replaced := false
+		for i := range pod.Status.Conditions {
+			if pod.Status.Conditions[i].Type == c.Type {
+				pod.Status.Conditions[i] = c
+				replaced = true
+			}
+		}
+		if !replaced {
+			pod.Status.Conditions = append(pod.Status.Conditions, c)
+		}
+	}
+	return nil
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to obj.
+func mergePatch(obj interface{}, patch map[string]interface{}) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	doc = mergeMaps(doc, patch)
+	b, err = json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	// Unmarshal merges into existing maps, so start from a zero value.
+	v := reflect.ValueOf(obj).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	return json.Unmarshal(b, obj)
+}
+
+func mergeMaps(doc map[string]interface{}, patch map[string]interface{}) map[string]interface{} {
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(doc, key)
+			continue
+		}
+		if sub, ok := value.(map[string]interface{}); ok {
+			current, _ := doc[key].(map[string]interface{})
+			doc[key] = mergeMaps(current, sub)
+			continue
+		}
+		doc[key] = value
+	}
+	return doc
+}
+
+func copyPod(pod *Pod) Pod {
+	var c Pod
+	b, _ := json.Marshal(pod)
+	json.Unmarshal(b, &c)
+	return c
+}
+
+// writeStatus writes a metav1.Status error response.
+func writeStatus(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]interface{}{
+		"kind":    "Status",
+		"status":  "Failure",
+		"message": msg,
+		"reason":  http.StatusText(status),
+		"code":    status,
+	})
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	pod := Pod{Metadata: Metadata{Name: "web", Annotations: map[string]string{"JobID": "1.server", "JobState": "Q"}}}
+	err := mergePatch(&pod, map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{"JobID": nil, "JobState": "R"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"JobState": "R"}
+	if !reflect.DeepEqual(pod.Metadata.Annotations, want) {
+		t.Errorf("annotations %v, want %v", pod.Metadata.Annotations, want)
+	}
+}
+
+func TestFakeAPIServerFieldSelectors(t *testing.T) {
+	c := newTestCluster(t, "node1")
+	c.api.AddPod("default", testPod("pending", "1", "100Mi"))
+	bound := testPod("bound", "1", "100Mi")
+	bound.Spec.NodeName = "node1"
+	c.api.AddPod("default", bound)
+	other := testPod("other", "1", "100Mi")
+	other.Spec.SchedulerName = "default-scheduler"
+	c.api.AddPod("default", other)
+
+	pods, err := getUnscheduledPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Metadata.Name != "pending" {
+		t.Errorf("unscheduled pods %v, want pending", pods.Items)
+	}
+	pods, err = getPods("spec.nodeName!=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Metadata.Name != "bound" {
+		t.Errorf("bound pods %v, want bound", pods.Items)
+	}
+}
+
+func TestFakeAPIServerInjectError(t *testing.T) {
+	c := newTestCluster(t)
+	c.api.AddPod("default", testPod("web", "1", "100Mi"))
+	c.api.InjectError(http.MethodPatch, "/api/v1/namespaces/default/pods/web", http.StatusInternalServerError, 1)
+	pod := c.pod(t, "web")
+
+	if err := annotation(&pod, "1.server"); err == nil {
+		t.Error("patch succeeded despite the injected error")
+	}
+	if err := annotation(&pod, "1.server"); err != nil {
+		t.Errorf("second patch: %v", err)
+	}
+	if got := c.pod(t, "web").Metadata.Annotations["JobID"]; got != "1.server" {
+		t.Errorf("JobID = %q, want 1.server", got)
+	}
+}
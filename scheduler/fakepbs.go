@@ -0,0 +1,601 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakePBS is an in-memory PBS server implementing PBS. It models queues,
+// vnode capacity, array jobs and the job life cycle (Q, H, R, S, E, F) well
+// enough to run the scheduler without a PBS installation. Jobs only start
+// when Schedule is called, which plays the role of a PBS scheduling cycle;
+// running jobs that finish or are deleted stay exiting (E), holding their
+// vnode, until the next cycle.
+type FakePBS struct {
+	// History keeps finished and deleted jobs visible to Status, as with
+	// job_history_enable set on the server.
+	History bool
+
+	mu     sync.Mutex
+	nextID int
+	queues map[string]bool
+	nodes  []*FakePBSNode
+	jobs   map[string]*fakeJob
+}
+
+// FakePBSNode is a vnode of the FakePBS server.
+type FakePBSNode struct {
+	Name  string
+	Ncpus int
+	MemMB int
+	// Resources holds the custom resources of the vnode, e.g. "ssd": "True".
+	Resources map[string]string
+
+	usedNcpus int
+	usedMemMB int
+}
+
+type fakeJob struct {
+	id        string
+	name      string
+	queue     string
+	priority  int
+	state     string
+	substate  string
+	comment   string
+	node      *FakePBSNode
+	ncpus     int
+	memMB     int
+	chunk     map[string]string
+	variables string
+	exitCode  string
+	// finalSubstate is the substate an exiting job finishes with.
+	finalSubstate string
+	script        string
+	owner         string
+	group         string
+	submitted     int
+}
+
+// NewFakePBS returns a fake PBS server with the default "workq" queue and
+// the given vnodes.
+func NewFakePBS(nodes ...*FakePBSNode) *FakePBS {
+	return &FakePBS{
+		History: true,
+		queues:  map[string]bool{"workq": true},
+		nodes:   nodes,
+		jobs:    map[string]*fakeJob{},
+	}
+}
+
+// AddQueue creates a queue.
+func (p *FakePBS) AddQueue(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queues[name] = true
+}
+
+// AddNode adds a vnode.
+func (p *FakePBS) AddNode(node *FakePBSNode) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nodes = append(p.nodes, node)
+}
+
+// Submit implements PBS by parsing the qsub options used by the scheduler.
+func (p *FakePBS) Submit(sub Submission) (string, error) {
+	args := sub.Args
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nextID++
+	job := &fakeJob{
+		id:        fmt.Sprintf("%d.fakepbs", p.nextID),
+		queue:     "workq",
+		state:     "Q",
+		substate:  "10",
+		chunk:     map[string]string{},
+		script:    sub.Script,
+		owner:     sub.User,
+		group:     sub.Group,
+		submitted: p.nextID,
+	}
+	first, last := -1, -1
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-h" {
+			job.state, job.substate = "H", "20"
+			continue
+		}
+		if !strings.HasPrefix(args[i], "-") || i+1 == len(args) {
+			continue
+		}
+		value := args[i+1]
+		switch args[i] {
+		case "-N":
+			job.name = value
+		case "-q":
+			job.queue = value
+		case "-v":
+			job.variables = value
+		case "-u":
+			job.owner = value
+		case "-W":
+			if strings.HasPrefix(value, "group_list=") {
+				job.group = strings.TrimPrefix(value, "group_list=")
+			}
+		case "-J":
+			_, err := fmt.Sscanf(value, "%d-%d", &first, &last)
+			if err != nil || first < 0 || last <= first {
+				return "", fmt.Errorf("qsub: illegal -J value")
+			}
+		case "-p":
+			prio, err := strconv.Atoi(value)
+			if err != nil {
+				return "", fmt.Errorf("qsub: illegal -p value")
+			}
+			job.priority = prio
+		case "-l":
+			if !strings.HasPrefix(value, "select=") {
+				break
+			}
+			err := job.parseSelect(strings.TrimPrefix(value, "select="))
+			if err != nil {
+				return "", err
+			}
+		default:
+			continue
+		}
+		i++
+	}
+	if !p.queues[job.queue] {
+		return "", fmt.Errorf("qsub: Unknown queue")
+	}
+	if first < 0 {
+		p.jobs[job.id] = job
+		return job.id, nil
+	}
+	// An array job is only a container of its subjobs, each scheduled as a
+	// job of its own.
+	id := strconv.Itoa(p.nextID)
+	for index := first; index <= last; index++ {
+		subjob := *job
+		subjob.id = fmt.Sprintf("%s[%d].fakepbs", id, index)
+		subjob.chunk = map[string]string{}
+		for key, value := range job.chunk {
+			subjob.chunk[key] = value
+		}
+		p.jobs[subjob.id] = &subjob
+	}
+	job.id = id + "[].fakepbs"
+	job.state, job.substate = "B", "11"
+	p.jobs[job.id] = job
+	return job.id, nil
+}
+
+func (j *fakeJob) parseSelect(sel string) error {
+	parts := strings.Split(sel, ":")
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("qsub: illegal attribute or resource value for select")
+		}
+		switch kv[0] {
+		case "ncpus":
+			n, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return fmt.Errorf("qsub: illegal attribute or resource value for select")
+			}
+			j.ncpus = n
+		case "mem":
+			mb, err := parseMemMB(kv[1])
+			if err != nil {
+				return fmt.Errorf("qsub: illegal attribute or resource value for mem")
+			}
+			j.memMB = mb
+		default:
+			j.chunk[kv[0]] = kv[1]
+		}
+	}
+	return nil
+}
+
+// Status implements PBS.
+func (p *FakePBS) Status(jobid string) (*JobStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	job, ok := p.jobs[jobid]
+	if !ok || (job.state == "F" && !p.History) {
+		return nil, errUnknownJob
+	}
+	return job.status(), nil
+}
+
+func (j *fakeJob) status() *JobStatus {
+	s := &JobStatus{
+		ID:       j.id,
+		Name:     j.name,
+		State:    j.state,
+		Substate: j.substate,
+		Queue:    j.queue,
+		Comment:  j.comment,
+		Attributes: map[string]string{
+			"Job_Name":             j.name,
+			"job_state":            j.state,
+			"substate":             j.substate,
+			"queue":                j.queue,
+			"Priority":             strconv.Itoa(j.priority),
+			"Resource_List.ncpus":  strconv.Itoa(j.ncpus),
+			"Resource_List.mem":    strconv.Itoa(j.memMB) + "mb",
+			"Resource_List.nodect": "1",
+			"Resource_List.select": j.selectString(),
+			"Variable_List":        j.variables,
+		},
+	}
+	if j.owner != "" {
+		s.Attributes["Job_Owner"] = j.owner + "@fakepbs"
+		s.Attributes["euser"] = j.owner
+	}
+	if j.group != "" {
+		s.Attributes["egroup"] = j.group
+	}
+	if j.comment != "" {
+		s.Attributes["comment"] = j.comment
+	}
+	if j.node != nil {
+		s.ExecHost = j.node.Name + "/0"
+		s.Attributes["exec_host"] = s.ExecHost
+		s.Attributes["exec_vnode"] = fmt.Sprintf("(%s:ncpus=%d:mem=%dmb)", j.node.Name, j.ncpus, j.memMB)
+	}
+	if j.exitCode != "" {
+		s.Attributes["Exit_status"] = j.exitCode
+	}
+	return s
+}
+
+func (j *fakeJob) selectString() string {
+	sel := fmt.Sprintf("1:ncpus=%d:mem=%dmb", j.ncpus, j.memMB)
+	keys := make([]string, 0, len(j.chunk))
+	for key := range j.chunk {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sel += ":" + key + "=" + j.chunk[key]
+	}
+	return sel
+}
+
+// Schedule runs a scheduling cycle: exiting jobs finish and release their
+// vnode, then queued jobs are started by descending priority, then
+// submission order, on the first vnode with enough free resources. Jobs
+// that cannot run get a PBS style comment.
+func (p *FakePBS) Schedule() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var queued []*fakeJob
+	for _, job := range p.jobs {
+		switch job.state {
+		case "E":
+			job.release()
+			job.state, job.substate = "F", job.finalSubstate
+		case "R":
+			if job.substate == "41" {
+				job.substate = "42"
+			}
+		case "Q":
+			queued = append(queued, job)
+		}
+	}
+	sort.Slice(queued, func(a, b int) bool {
+		if queued[a].priority != queued[b].priority {
+			return queued[a].priority > queued[b].priority
+		}
+		return queued[a].submitted < queued[b].submitted
+	})
+
+	for _, job := range queued {
+		reason := "Insufficient amount of resource: ncpus"
+		for _, node := range p.nodes {
+			r := node.fits(job)
+			if r == "" {
+				job.start(node)
+				reason = ""
+				break
+			}
+			reason = r
+		}
+		if reason != "" {
+			job.comment = "Not Running: " + reason
+		}
+	}
+}
+
+// fits returns why the job cannot run on the node, or "".
+func (n *FakePBSNode) fits(job *fakeJob) string {
+	if host, ok := job.chunk["host"]; ok && host != n.Name {
+		return "Insufficient amount of resource: host"
+	}
+	for key, value := range job.chunk {
+		if key == "host" || key == "vnode" {
+			continue
+		}
+		have := n.Resources[key]
+		if have == "" && value == "False" {
+			continue
+		}
+		if have != value {
+			return "Insufficient amount of resource: " + key
+		}
+	}
+	if n.Ncpus-n.usedNcpus < job.ncpus {
+		return fmt.Sprintf("Insufficient amount of resource: ncpus (R: %d A: %d T: %d)", job.ncpus, n.Ncpus-n.usedNcpus, n.Ncpus)
+	}
+	if n.MemMB-n.usedMemMB < job.memMB {
+		return fmt.Sprintf("Insufficient amount of resource: mem (R: %dmb A: %dmb T: %dmb)", job.memMB, n.MemMB-n.usedMemMB, n.MemMB)
+	}
+	return ""
+}
+
+func (j *fakeJob) start(node *FakePBSNode) {
+	node.usedNcpus += j.ncpus
+	node.usedMemMB += j.memMB
+	j.node = node
+	// Like the server, report the job running (41) until the next pass
+	// finds it executing (42).
+	j.state = "R"
+	j.substate = "41"
+	j.comment = "Job run at " + time.Now().Format("Mon Jan 02 at 15:04") + " on (" + node.Name + ")"
+}
+
+func (j *fakeJob) release() {
+	if j.node != nil {
+		j.node.usedNcpus -= j.ncpus
+		j.node.usedMemMB -= j.memMB
+		j.node = nil
+	}
+}
+
+// transition applies fn to a job, failing for unknown jobs.
+func (p *FakePBS) transition(jobid string, fn func(job *fakeJob) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	job, ok := p.jobs[jobid]
+	if !ok || job.state == "F" {
+		return errUnknownJob
+	}
+	return fn(job)
+}
+
+// Hold puts a queued job on hold, as qhold does.
+func (p *FakePBS) Hold(jobid string) error {
+	return p.transition(jobid, func(job *fakeJob) error {
+		if job.state != "Q" {
+			return fmt.Errorf("qhold: Request invalid for state of job")
+		}
+		job.state, job.substate = "H", "20"
+		return nil
+	})
+}
+
+// Release releases a held job, as qrls does.
+func (p *FakePBS) Release(jobid string) error {
+	return p.transition(jobid, func(job *fakeJob) error {
+		if job.state != "H" {
+			return fmt.Errorf("qrls: Request invalid for state of job")
+		}
+		job.state, job.substate = "Q", "10"
+		return nil
+	})
+}
+
+// Suspend suspends a running job, as preemption by suspension does.
+func (p *FakePBS) Suspend(jobid string) error {
+	return p.transition(jobid, func(job *fakeJob) error {
+		if job.state != "R" {
+			return fmt.Errorf("qsig: Request invalid for state of job")
+		}
+		job.state, job.substate = "S", "43"
+		job.comment = "Job suspended"
+		return nil
+	})
+}
+
+// Resume resumes a suspended job.
+func (p *FakePBS) Resume(jobid string) error {
+	return p.transition(jobid, func(job *fakeJob) error {
+		if job.state != "S" {
+			return fmt.Errorf("qsig: Request invalid for state of job")
+		}
+		job.state, job.substate = "R", "42"
+		return nil
+	})
+}
+
+// Requeue puts a running job back in its queue, releasing its vnode, as
+// preemption by requeue or checkpoint does.
+func (p *FakePBS) Requeue(jobid string) error {
+	return p.transition(jobid, func(job *fakeJob) error {
+		if job.state != "R" && job.state != "S" {
+			return fmt.Errorf("qrerun: Request invalid for state of job")
+		}
+		job.release()
+		job.state, job.substate = "Q", "10"
+		job.comment = "Job requeued"
+		return nil
+	})
+}
+
+// Finish ends a running job with the given exit status. The job exits (E)
+// until the next scheduling cycle.
+func (p *FakePBS) Finish(jobid string, exitStatus int) error {
+	return p.transition(jobid, func(job *fakeJob) error {
+		if job.state != "R" && job.state != "S" {
+			return fmt.Errorf("Request invalid for state of job")
+		}
+		job.state, job.substate, job.finalSubstate = "E", "51", "92"
+		job.exitCode = strconv.Itoa(exitStatus)
+		job.comment = "Job run at " + time.Now().Format("Mon Jan 02 at 15:04") + " and finished"
+		return nil
+	})
+}
+
+// Delete deletes a job, as qdel does. A running job exits (E) until the
+// next scheduling cycle, other jobs finish at once.
+func (p *FakePBS) Delete(jobid string) error {
+	return p.transition(jobid, func(job *fakeJob) error {
+		job.comment = "Job deleted"
+		if job.state == "R" || job.state == "S" {
+			job.state, job.substate, job.finalSubstate = "E", "53", "91"
+			return nil
+		}
+		if job.state == "E" {
+			return nil
+		}
+		job.release()
+		job.state, job.substate = "F", "91"
+		return nil
+	})
+}
+
+// StatusJobs implements PBS.
+func (p *FakePBS) StatusJobs(jobids []string) ([]*JobStatus, error) {
+	var statuses []*JobStatus
+	for _, jobid := range jobids {
+		s, err := p.Status(jobid)
+		if err == errUnknownJob {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// Queues implements PBS.
+func (p *FakePBS) Queues() ([]*PBSObject, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var queues []*PBSObject
+	for name := range p.queues {
+		queues = append(queues, &PBSObject{
+			Name: name,
+			Attributes: map[string]string{
+				"queue_type": "Execution",
+				"enabled":    "True",
+				"started":    "True",
+			},
+		})
+	}
+	sort.Slice(queues, func(a, b int) bool {
+		return queues[a].Name < queues[b].Name
+	})
+	return queues, nil
+}
+
+// Nodes implements PBS.
+func (p *FakePBS) Nodes() ([]*PBSObject, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var nodes []*PBSObject
+	for _, n := range p.nodes {
+		o := &PBSObject{
+			Name: n.Name,
+			Attributes: map[string]string{
+				"resources_available.ncpus": strconv.Itoa(n.Ncpus),
+				"resources_available.mem":   strconv.Itoa(n.MemMB) + "mb",
+				"resources_assigned.ncpus":  strconv.Itoa(n.usedNcpus),
+				"resources_assigned.mem":    strconv.Itoa(n.usedMemMB) + "mb",
+				"resources_available.vnode": n.Name,
+				"resources_available.host":  n.Name,
+			},
+		}
+		for key, value := range n.Resources {
+			o.Attributes["resources_available."+key] = value
+		}
+		nodes = append(nodes, o)
+	}
+	return nodes, nil
+}
+
+// Jobs returns the status of every job, including finished ones.
+func (p *FakePBS) Jobs() []*JobStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var statuses []*JobStatus
+	for _, job := range p.jobs {
+		statuses = append(statuses, job.status())
+	}
+	sort.Slice(statuses, func(a, b int) bool {
+		return p.jobs[statuses[a].ID].submitted < p.jobs[statuses[b].ID].submitted
+	})
+	return statuses
+}
+
+// Utilization returns the ncpus in use by running jobs and the total ncpus
+// of the vnodes.
+func (p *FakePBS) Utilization() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	used, total := 0, 0
+	for _, node := range p.nodes {
+		used += node.usedNcpus
+		total += node.Ncpus
+	}
+	return used, total
+}
+
+// Script returns the job script a job was submitted with.
+func (p *FakePBS) Script(jobid string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if job, ok := p.jobs[jobid]; ok {
+		return job.script
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestFakePBSArraySubmission(t *testing.T) {
+	pbs := NewFakePBS(&FakePBSNode{Name: "node1", Ncpus: 2, MemMB: 1024})
+	jobid, err := pbs.Submit(Submission{Args: []string{"-J", "0-2", "-l", "select=1:ncpus=1:mem=100MB"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobid != "1[].fakepbs" {
+		t.Fatalf("array job id %s, want 1[].fakepbs", jobid)
+	}
+	pbs.Schedule()
+	running := 0
+	for i := 0; i < 3; i++ {
+		status, err := pbs.Status(subjobID(jobid, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.State == "R" {
+			running++
+		}
+	}
+	if running != 2 {
+		t.Errorf("%d subjobs running on 2 cpus, want 2", running)
+	}
+	_, err = pbs.Submit(Submission{Args: []string{"-q", "missing"}})
+	if err == nil {
+		t.Error("submission to an unknown queue succeeded")
+	}
+}
+
+func TestFakePBSExitingJobs(t *testing.T) {
+	pbs := NewFakePBS(&FakePBSNode{Name: "node1", Ncpus: 1, MemMB: 1024})
+	first, _ := pbs.Submit(Submission{Args: []string{"-l", "select=1:ncpus=1:mem=100MB"}})
+	second, _ := pbs.Submit(Submission{Args: []string{"-l", "select=1:ncpus=1:mem=100MB"}})
+	pbs.Schedule()
+	err := pbs.Finish(first, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, _ := pbs.Status(first)
+	if status.State != "E" {
+		t.Errorf("finished job in state %s, want E", status.State)
+	}
+	if status, _ := pbs.Status(second); status.State != "Q" {
+		t.Errorf("second job in state %s while the first exits, want Q", status.State)
+	}
+	pbs.Schedule()
+	if status, _ := pbs.Status(first); status.State != "F" {
+		t.Errorf("exited job in state %s, want F", status.State)
+	}
+	if status, _ := pbs.Status(second); status.State != "R" {
+		t.Errorf("second job in state %s once the first exited, want R", status.State)
+	}
+}
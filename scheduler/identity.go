@@ -0,0 +1,166 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// IdentityConfig maps pods to the PBS user, and optionally group, their
+// jobs are submitted as, so that PBS fairshare, limits and accounting see
+// the real owner of the work.
+type IdentityConfig struct {
+	// Source selects where the identity of a pod is read: "namespace",
+	// "service_account", "annotation" or "label". Without a source, jobs
+	// are submitted as the user running the scheduler.
+	Source string `json:"source"`
+	// Key is the annotation or label holding the identity.
+	Key string `json:"key"`
+	// Users maps identities to "user" or "user:group". Identities missing
+	// from the map are used as the user name with the namespace and
+	// service_account sources, and rejected with the annotation and label
+	// sources, whose identities the author of the pod picks.
+	Users map[string]string `json:"users"`
+	// DefaultUser, "user" or "user:group", is used for pods without an
+	// identity; without one such pods are rejected.
+	DefaultUser string `json:"default_user"`
+	// Deny lists the users jobs must never run as, by default root.
+	Deny []string `json:"deny"`
+	// Method selects how jobs are submitted on behalf of the user: "sudo"
+	// (the default) runs qsub through sudo as the user, "attributes" sets
+	// the job owner with qsub -u and -W group_list, which the PBS server
+	// must be configured to accept from the scheduler's user.
+	Method string `json:"method"`
+}
+
+// pbsName matches the user and group names accepted in a mapping.
+var pbsName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+func (c *IdentityConfig) validate() error {
+	switch c.Source {
+	case "", "namespace", "service_account":
+	case "annotation", "label":
+		if c.Key == "" {
+			return fmt.Errorf("key is required with source %q", c.Source)
+		}
+	default:
+		return fmt.Errorf("unknown source %q", c.Source)
+	}
+	switch c.Method {
+	case "", "sudo", "attributes":
+	default:
+		return fmt.Errorf("unknown method %q", c.Method)
+	}
+	return nil
+}
+
+// podIdentity returns the identity of the pod from the configured source.
+func (c *IdentityConfig) podIdentity(pod *Pod) string {
+	switch c.Source {
+	case "namespace":
+		return namespaceOf(pod)
+	case "service_account":
+		if pod.Spec.ServiceAccountName == "" {
+			return "default"
+		}
+		return pod.Spec.ServiceAccountName
+	case "annotation":
+		return pod.Metadata.Annotations[c.Key]
+	case "label":
+		return pod.Metadata.Labels[c.Key]
+	}
+	return ""
+}
+
+// jobOwner returns the user and group the pod's job must be submitted as,
+// both empty when jobs are submitted as the scheduler's user.
+func jobOwner(pod *Pod) (string, string, error) {
+	c := &config.Identity
+	if c.Source == "" {
+		return "", "", nil
+	}
+	identity := c.podIdentity(pod)
+	owner := identity
+	mapped, ok := c.Users[identity]
+	switch {
+	case identity == "":
+		owner = c.DefaultUser
+	case ok:
+		owner = mapped
+	case c.Source == "annotation" || c.Source == "label":
+		return "", "", fmt.Errorf("%s %s=%q is not a known PBS identity", c.Source, c.Key, identity)
+	}
+	if owner == "" {
+		return "", "", fmt.Errorf("no PBS user for the pod's %s", strings.Replace(c.Source, "_", " ", -1))
+	}
+
+	user, group := owner, ""
+	if i := strings.Index(owner, ":"); i >= 0 {
+		user, group = owner[:i], owner[i+1:]
+	}
+	if !pbsName.MatchString(user) || (group != "" && !pbsName.MatchString(group)) {
+		return "", "", fmt.Errorf("%q is not a valid PBS user", owner)
+	}
+	deny := c.Deny
+	if deny == nil {
+		deny = []string{"root"}
+	}
+	for _, denied := range deny {
+		if user == denied || (group != "" && group == denied) {
+			return "", "", fmt.Errorf("jobs may not run as %s", denied)
+		}
+	}
+	return user, group, nil
+}
+
+// submission prepares the submission of a job on behalf of its owner.
+func (c *IdentityConfig) submission(args []string, script string, user string, group string) Submission {
+	if user == "" || c.Method == "attributes" {
+		if user != "" {
+			args = append(args, "-u", user)
+		}
+		if group != "" {
+			args = append(args, "-W", "group_list="+group)
+		}
+		return Submission{Args: args, Script: script}
+	}
+	return Submission{Args: args, Script: script, User: user, Group: group}
+}
@@ -0,0 +1,269 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+)
+
+// JobScriptConfig selects the script of the PBS job holding the resources
+// of a pod while it runs.
+type JobScriptConfig struct {
+	// Mode selects a built-in script: "docker" (the default) waits for the
+	// pod's containers to disappear from the execution host, then exits
+	// with the exit status of the pod, "sleep" holds the resources until
+	// the job is deleted or reaches its walltime, "poll" polls the pod
+	// through the API server and exits with the exit status of the pod once
+	// it terminates and "heartbeat" also stamps the pod with a JobHeartbeat
+	// annotation.
+	Mode string `json:"mode"`
+	// Template is the path of a text/template job script replacing the
+	// built-in ones.
+	Template string `json:"template"`
+	// APIServer is the API server URL the job scripts use, as reached from
+	// the execution hosts. The poll and heartbeat scripts require it; the
+	// docker script only waits for the exit status of the pod with it.
+	APIServer string `json:"api_server"`
+	// PollInterval is the number of seconds between two polls of the pod.
+	PollInterval int `json:"poll_interval"`
+	// ExitGrace is the number of seconds a job is given to exit by itself
+	// once its pod terminated, before the scheduler deletes it.
+	ExitGrace int `json:"exit_grace"`
+
+	tmpl *template.Template
+}
+
+// JobScriptData holds the variables available to job script templates.
+type JobScriptData struct {
+	Namespace    string
+	Name         string
+	UID          string
+	Ncpus        string
+	Mem          string
+	APIServer    string
+	PollInterval int
+	Prologue     string
+	Epilogue     string
+	// Pods maps the subjob indices of an array job to their pod. Name and
+	// UID then expand to $PODNAME and $PODUID, set from $PBS_ARRAY_INDEX.
+	Pods []JobScriptPod
+}
+
+// JobScriptPod is the pod of a subjob of an array job.
+type JobScriptPod struct {
+	Index int
+	Name  string
+	UID   string
+}
+
+// arraySetup sets the pod of the subjob in array jobs.
+const arraySetup = `{{if .Pods}}case "$PBS_ARRAY_INDEX" in
+{{range .Pods}}{{.Index}}) PODNAME={{.Name}} PODUID={{.UID}} ;;
+{{end}}esac
+{{end}}`
+
+// hookSetup runs the prologue of the pod's job template and arranges for
+// its epilogue to run when the job script exits.
+const hookSetup = `{{if .Epilogue}}epilogue() {
+{{.Epilogue}}
+}
+trap epilogue EXIT
+{{end}}{{if .Prologue}}{{.Prologue}}
+{{end}}`
+
+// The built-in job scripts.
+var jobScriptTemplates = map[string]string{
+	"docker": `#PBS -joe -o localhost:/tmp
+` + arraySetup + hookSetup + `sleep 30
+while :
+do
+	docker ps | grep {{.Name}}
+	if [ $? -ne 0 ]; then
+		break
+	else
+		sleep {{.PollInterval}}
+	fi
+done
+{{if .APIServer}}` + exitStatusWait + `{{else}}exit 0
+{{end}}`,
+	"sleep": `#PBS -j oe
+` + arraySetup + hookSetup + `while :
+do
+	sleep 3600
+done
+`,
+	"poll":      pollScript(""),
+	"heartbeat": pollScript(heartbeatStep),
+}
+
+// exitStatusWait waits for the scheduler to publish the exit status of the
+// terminated pod, for up to 12 polls, and exits with it.
+const exitStatusWait = `url="{{.APIServer}}/api/v1/namespaces/{{.Namespace}}/pods/{{.Name}}"
+tries=0
+while [ $tries -lt 12 ]
+do
+	pod=$(curl -s "$url" | tr -d ' \n')
+	status=$(printf '%s' "$pod" | sed -n 's/.*"JobExitStatus":"\([0-9]*\)".*/\1/p')
+	if [ -n "$status" ]; then
+		exit "$status"
+	fi
+	case "$pod" in
+	*"\"uid\":\"{{.UID}}\""*) ;;
+	"") ;;
+	*) exit 0 ;;
+	esac
+	tries=$((tries + 1))
+	sleep {{.PollInterval}}
+done
+exit 0
+`
+
+// heartbeatStep stamps the pod with the time of the last poll.
+const heartbeatStep = `	now=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+	curl -s -o /dev/null -X PATCH -H 'Content-Type: application/merge-patch+json' \
+		-d "{\"metadata\":{\"annotations\":{\"JobHeartbeat\":\"$now\"}}}" "$url"
+`
+
+// pollScript returns a job script polling the pod until the scheduler
+// publishes its exit status or the pod is deleted, running step after each
+// poll.
+func pollScript(step string) string {
+	return `#PBS -j oe
+` + arraySetup + hookSetup + `url="{{.APIServer}}/api/v1/namespaces/{{.Namespace}}/pods/{{.Name}}"
+tmp=$(mktemp)
+trap 'rm -f "$tmp"{{if .Epilogue}}; epilogue{{end}}' EXIT
+while :
+do
+	code=$(curl -s -o "$tmp" -w '%{http_code}' "$url")
+	if [ "$code" = 404 ]; then
+		exit 0
+	fi
+	pod=$(tr -d ' \n' < "$tmp")
+	case "$pod" in
+	*"\"uid\":\"{{.UID}}\""*) ;;
+	"") ;;
+	*) exit 0 ;;
+	esac
+	status=$(printf '%s' "$pod" | sed -n 's/.*"JobExitStatus":"\([0-9]*\)".*/\1/p')
+	if [ -n "$status" ]; then
+		exit "$status"
+	fi
+` + step + `	sleep {{.PollInterval}}
+done
+`
+}
+
+// load parses the template of the job script.
+func (c *JobScriptConfig) load() error {
+	if c.APIServer != "" && !strings.HasPrefix(c.APIServer, "http://") && !strings.HasPrefix(c.APIServer, "https://") {
+		return fmt.Errorf("api_server %q is not an http or https URL", c.APIServer)
+	}
+	if c.Template == "" && (c.Mode == "poll" || c.Mode == "heartbeat") && c.APIServer == "" {
+		return fmt.Errorf("api_server is required by the %s job script: the URL of the API server as reached from the execution hosts", c.Mode)
+	}
+	if c.Template != "" {
+		b, err := ioutil.ReadFile(c.Template)
+		if err != nil {
+			return err
+		}
+		c.tmpl, err = template.New(c.Template).Option("missingkey=error").Parse(string(b))
+		return err
+	}
+	text, ok := jobScriptTemplates[c.name()]
+	if !ok {
+		return fmt.Errorf("unknown job script mode %q", c.Mode)
+	}
+	var err error
+	c.tmpl, err = template.New(c.name()).Parse(text)
+	return err
+}
+
+// name describes the job script in use.
+func (c *JobScriptConfig) name() string {
+	if c.Template != "" {
+		return c.Template
+	}
+	if c.Mode == "" {
+		return "docker"
+	}
+	return c.Mode
+}
+
+// jobScript renders the job script of the pod, with the hooks of its job
+// template, or of the pods of the array.
+func jobScript(pod *Pod, ncpus string, mem string, hooks PBSJobHooks, array *jobArray) (string, error) {
+	c := &config.JobScript
+	if c.tmpl == nil {
+		err := c.load()
+		if err != nil {
+			return "", fmt.Errorf("Job script: %v", err)
+		}
+	}
+	data := JobScriptData{
+		Namespace:    namespaceOf(pod),
+		Name:         pod.Metadata.Name,
+		UID:          pod.Metadata.Uid,
+		Ncpus:        ncpus,
+		Mem:          mem,
+		APIServer:    c.APIServer,
+		PollInterval: c.PollInterval,
+		Prologue:     strings.TrimRight(hooks.Prologue, "\n"),
+		Epilogue:     strings.TrimRight(hooks.Epilogue, "\n"),
+	}
+	if array != nil {
+		data.Name, data.UID = "$PODNAME", "$PODUID"
+		for i := array.first; i <= array.last; i++ {
+			p := array.pods[i]
+			data.Pods = append(data.Pods, JobScriptPod{Index: i, Name: p.Metadata.Name, UID: p.Metadata.Uid})
+		}
+	}
+	if data.PollInterval <= 0 {
+		data.PollInterval = 5
+	}
+	var script bytes.Buffer
+	err := c.tmpl.Execute(&script, data)
+	if err != nil {
+		return "", fmt.Errorf("Job script: %v", err)
+	}
+	return script.String(), nil
+}
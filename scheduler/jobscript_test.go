@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJobScriptConfigRequiresAPIServer(t *testing.T) {
+	tests := []struct {
+		config JobScriptConfig
+		valid  bool
+	}{
+		{JobScriptConfig{}, true},
+		{JobScriptConfig{Mode: "sleep"}, true},
+		{JobScriptConfig{Mode: "poll"}, false},
+		{JobScriptConfig{Mode: "heartbeat"}, false},
+		{JobScriptConfig{Mode: "poll", APIServer: "http://10.0.0.4:8001"}, true},
+		{JobScriptConfig{Mode: "poll", APIServer: "10.0.0.4:8001"}, false},
+	}
+	for _, tt := range tests {
+		err := tt.config.load()
+		if (err == nil) != tt.valid {
+			t.Errorf("load(%+v) = %v, want valid %v", tt.config, err, tt.valid)
+		}
+	}
+}
+
+func TestDockerJobScriptWaitsForExitStatusWithAPIServer(t *testing.T) {
+	newTestCluster(t)
+	pod := testPod("web", "1", "100Mi")
+	pod.Metadata.Namespace = "default"
+
+	config.JobScript = JobScriptConfig{}
+	script, err := jobScript(&pod, "1", "100mb", PBSJobHooks{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(script, "curl") || !strings.HasSuffix(script, "done\nexit 0\n") {
+		t.Errorf("docker script without api_server calls the API server:\n%s", script)
+	}
+
+	config.JobScript = JobScriptConfig{APIServer: "http://10.0.0.4:8001"}
+	script, err = jobScript(&pod, "1", "100mb", PBSJobHooks{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(script, `url="http://10.0.0.4:8001/api/v1/namespaces/default/pods/web"`) {
+		t.Errorf("docker script does not wait for the exit status on api_server:\n%s", script)
+	}
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Pod annotations mirroring the status of the pod's PBS job.
+const (
+	jobStateAnnotation          = "JobState"
+	jobQueueAnnotation          = "JobQueue"
+	jobCommentAnnotation        = "JobComment"
+	jobEstimatedStartAnnotation = "JobEstimatedStartTime"
+	jobExecHostAnnotation       = "JobExecHost"
+)
+
+// jobStateNames gives a readable name to the PBS job states.
+var jobStateNames = map[string]string{
+	"B": "ArrayBegun",
+	"E": "Exiting",
+	"F": "Finished",
+	"H": "Held",
+	"M": "Moved",
+	"Q": "Queued",
+	"R": "Running",
+	"S": "Suspended",
+	"T": "Transiting",
+	"U": "UserBusy",
+	"W": "Waiting",
+	"X": "SubjobFinished",
+}
+
+// jobAnnotations returns the pod annotations describing the job. Empty
+// values mean the annotation must be removed.
+func jobAnnotations(status *JobStatus) map[string]string {
+	return map[string]string{
+		jobStateAnnotation:          status.State,
+		jobQueueAnnotation:          status.Queue,
+		jobCommentAnnotation:        status.Comment,
+		jobEstimatedStartAnnotation: status.EstimatedStartTime,
+		jobExecHostAnnotation:       status.ExecHost,
+	}
+}
+
+// scheduledCondition returns the PodScheduled condition of a pod whose job
+// has not been started by PBS yet.
+func scheduledCondition(status *JobStatus) PodCondition {
+	reason := "PBSJob" + status.State
+	if name, ok := jobStateNames[status.State]; ok {
+		reason = "PBSJob" + name
+	}
+	msg := fmt.Sprintf("PBS job %s is %s in queue %s", status.ID, status.State, status.Queue)
+	if status.EstimatedStartTime != "" {
+		msg += ", estimated to start at " + status.EstimatedStartTime
+	}
+	if status.Comment != "" {
+		msg += ": " + status.Comment
+	}
+	return PodCondition{
+		Type:    "PodScheduled",
+		Status:  "False",
+		Reason:  reason,
+		Message: msg,
+	}
+}
+
+// reflectJobStatus keeps the job annotations of the pod and, for pods not
+// bound yet, its PodScheduled condition in sync with the PBS job status.
+// Nothing is sent to the API server when the pod is already up to date.
+func reflectJobStatus(pod *Pod, status *JobStatus) error {
+	changes := map[string]interface{}{}
+	for key, value := range jobAnnotations(status) {
+		current, ok := pod.Metadata.Annotations[key]
+		if current == value && (ok || value == "") {
+			continue
+		}
+		if value == "" {
+			changes[key] = nil
+		} else {
+			changes[key] = value
+		}
+	}
+	if len(changes) > 0 {
+		patch := map[string]interface{}{
+			"metadata": map[string]interface{}{"annotations": changes},
+		}
+		err := patchPod(pod, "", patch)
+		if err != nil {
+			return err
+		}
+		if _, ok := changes[jobStateAnnotation]; ok {
+			recordJob(pod, pod.Metadata.Annotations["JobID"], jobState, status.State, "")
+		}
+		if pod.Metadata.Annotations == nil {
+			pod.Metadata.Annotations = map[string]string{}
+		}
+		for key, value := range changes {
+			if value == nil {
+				delete(pod.Metadata.Annotations, key)
+			} else {
+				pod.Metadata.Annotations[key] = value.(string)
+			}
+		}
+	}
+
+	if pod.Spec.NodeName != "" {
+		return nil
+	}
+	condition := scheduledCondition(status)
+	for _, c := range pod.Status.Conditions {
+		if c.Type != condition.Type {
+			continue
+		}
+		if c.Status == condition.Status && c.Reason == condition.Reason && c.Message == condition.Message {
+			return nil
+		}
+		if c.Status == condition.Status {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+	}
+	if condition.LastTransitionTime == "" {
+		condition.LastTransitionTime = time.Now().UTC().Format(time.RFC3339)
+	}
+	patch := map[string]interface{}{
+		"status": PodStatus{Conditions: []PodCondition{condition}},
+	}
+	return patchPod(pod, "status", patch)
+}
@@ -0,0 +1,278 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const jobTemplateAnnotation = "PBSJobTemplate"
+
+var (
+	// resourceFormat matches the name=value resources of a select chunk.
+	resourceFormat = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=[^:=+,]+$`)
+	// identifierFormat matches job attribute and environment variable names.
+	identifierFormat = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// placeKeywords are the values of the place job resource.
+	placeKeywords = map[string]bool{
+		"free": true, "pack": true, "scatter": true, "vscatter": true,
+		"excl": true, "shared": true, "exclhost": true,
+	}
+	// reservedVariables are the environment variables set by the scheduler.
+	reservedVariables = map[string]bool{"PODNAME": true, "PODNAMESPACE": true, "PODWORKLOAD": true}
+)
+
+// JobTemplateConfig restricts what the namespaced PBSJobTemplates, which
+// the users of a namespace may write, can set.
+type JobTemplateConfig struct {
+	// AllowHooks lets templates run their prologue and epilogue shell
+	// commands in the job script on the execution hosts.
+	AllowHooks bool `json:"allow_hooks"`
+	// Attributes lists the job attributes templates may set with qsub -W.
+	Attributes []string `json:"attributes"`
+}
+
+// attributeAllowed reports whether templates may set the job attribute.
+func (c *JobTemplateConfig) attributeAllowed(name string) bool {
+	for _, a := range c.Attributes {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the template can be expanded into qsub options and only
+// uses the options the scheduler config allows.
+func (s *PBSJobTemplateSpec) validate() error {
+	if s.Select != "" {
+		for _, res := range strings.Split(s.Select, ":") {
+			if !resourceFormat.MatchString(res) {
+				return fmt.Errorf("invalid select resource %q", res)
+			}
+			name := res[:strings.Index(res, "=")]
+			if name == "ncpus" || name == "mem" {
+				return fmt.Errorf("select cannot set %s, it comes from the pod's requests", name)
+			}
+		}
+	}
+	if s.Place != "" {
+		for _, word := range strings.Split(s.Place, ":") {
+			if !placeKeywords[word] && !strings.HasPrefix(word, "group=") {
+				return fmt.Errorf("invalid place %q", s.Place)
+			}
+		}
+	}
+	if s.Walltime != "" && !walltimeFormat.MatchString(s.Walltime) {
+		return fmt.Errorf("invalid walltime %q", s.Walltime)
+	}
+	for name, value := range s.Attributes {
+		if !identifierFormat.MatchString(name) {
+			return fmt.Errorf("invalid job attribute %q", name)
+		}
+		if !config.JobTemplates.attributeAllowed(name) {
+			return fmt.Errorf("job attribute %q is not allowed by the scheduler config", name)
+		}
+		if value == "" {
+			return fmt.Errorf("job attribute %q has no value", name)
+		}
+	}
+	for name, value := range s.Environment {
+		if !identifierFormat.MatchString(name) || reservedVariables[name] {
+			return fmt.Errorf("invalid environment variable %q", name)
+		}
+		if strings.ContainsAny(value, ",'\"") {
+			return fmt.Errorf("environment variable %q cannot contain commas or quotes", name)
+		}
+	}
+	if (s.Hooks.Prologue != "" || s.Hooks.Epilogue != "") && !config.JobTemplates.AllowHooks {
+		return fmt.Errorf("hooks are not allowed by the scheduler config")
+	}
+	return nil
+}
+
+// chunk returns the resources the template adds to the select chunk.
+func (s *PBSJobTemplateSpec) chunk() string {
+	if s.Select == "" {
+		return ""
+	}
+	return ":" + s.Select
+}
+
+// variables returns the template environment in the format of qsub -v.
+func (s *PBSJobTemplateSpec) variables() string {
+	var vars []string
+	for name, value := range s.Environment {
+		vars = append(vars, name+"="+value)
+	}
+	sort.Strings(vars)
+	if len(vars) == 0 {
+		return ""
+	}
+	return "," + strings.Join(vars, ",")
+}
+
+// args returns the place and job attribute qsub options of the template.
+// The queue and walltime are applied through the namespace policy, which
+// wins unless it leaves them unset or lists them in its overrides.
+func (s *PBSJobTemplateSpec) args() []string {
+	var args []string
+	if s.Place != "" {
+		args = append(args, "-l", "place="+s.Place)
+	}
+	var names []string
+	for name := range s.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "-W", name+"="+s.Attributes[name])
+	}
+	return args
+}
+
+// jobTemplateCache holds the PBSJobTemplates received from the watch, by
+// namespace and name.
+type jobTemplateCache struct {
+	mu        sync.Mutex
+	templates map[string]*PBSJobTemplate
+}
+
+var jobTemplates = &jobTemplateCache{templates: map[string]*PBSJobTemplate{}}
+
+func (c *jobTemplateCache) update(event PBSJobTemplateWatchEvent) {
+	t := event.Object
+	key := t.Metadata.Namespace + "/" + t.Metadata.Name
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch event.Type {
+	case "ADDED", "MODIFIED":
+		err := t.Spec.validate()
+		if err != nil {
+			log.Printf("PBSJobTemplate %s is invalid: %v", key, err)
+		}
+		c.templates[key] = &t
+	case "DELETED":
+		delete(c.templates, key)
+	}
+}
+
+// replace replaces the cache with the templates of a list.
+func (c *jobTemplateCache) replace(list *PBSJobTemplateList) {
+	templates := map[string]*PBSJobTemplate{}
+	for i := range list.Items {
+		t := &list.Items[i]
+		templates[t.Metadata.Namespace+"/"+t.Metadata.Name] = t
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.templates = templates
+}
+
+func (c *jobTemplateCache) get(namespace string, name string) *PBSJobTemplate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.templates[namespace+"/"+name]
+}
+
+// trackJobTemplates keeps the template cache up to date. The templates are
+// listed again whenever the watch breaks, so that templates deleted while
+// it reconnects leave the cache. Without the PBSJobTemplate CRD, templates
+// are looked up when pods reference them.
+func trackJobTemplates(done chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ok, err := apiAvailable(jobTemplateGroup)
+	if err != nil || !ok {
+		log.Println("PBSJobTemplate API not served, job templates are not watched")
+		return
+	}
+	relist := func() {
+		list, err := listJobTemplates()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		jobTemplates.replace(list)
+	}
+	relist()
+	events, errc := watchJobTemplates()
+	for {
+		select {
+		case err := <-errc:
+			log.Println(err)
+			relist()
+		case event := <-events:
+			jobTemplates.update(event)
+		case <-done:
+			log.Println("Stopped job template tracking.")
+			return
+		}
+	}
+}
+
+// podJobTemplate returns the validated spec of the template the pod
+// references, or an empty spec if it references none.
+func podJobTemplate(pod *Pod) (*PBSJobTemplateSpec, error) {
+	name := pod.Metadata.Annotations[jobTemplateAnnotation]
+	if name == "" {
+		return &PBSJobTemplateSpec{}, nil
+	}
+	namespace := namespaceOf(pod)
+	t := jobTemplates.get(namespace, name)
+	if t == nil {
+		var err error
+		t, err = getJobTemplate(namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			return nil, fmt.Errorf("PBSJobTemplate %s/%s not found", namespace, name)
+		}
+	}
+	err := t.Spec.validate()
+	if err != nil {
+		return nil, fmt.Errorf("PBSJobTemplate %s/%s: %v", namespace, name, err)
+	}
+	return &t.Spec, nil
+}
@@ -45,8 +45,6 @@ import (
 	"io/ioutil"
 	"log"
 	"fmt"
-	"os"
-	"os/exec"         
 	"net/http"
 	"net/url"
 	"strconv"
@@ -64,26 +62,40 @@ type PBSPod struct {
 
 type PBSPodMetadata struct {
 	Name        string            `json:"name,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
 	Annotations map[string]string `json:"annotations"`
 }
 
 
 var (
-	apiHost           = "127.0.0.1:8001"
-	bindingEndpoint  = "/api/v1/namespaces/default/pods/%s/binding/"
-	eventEndpoint    = "/api/v1/namespaces/default/events"
-	nodeEndpoint     = "/api/v1/nodes"
-	podEndpoint      = "/api/v1/pods"
-	podNamespace	  = "/api/v1/namespaces/default/pods/"
-	watchPodEndpoint = "/api/v1/watch/pods"
+	apiHost                  = "127.0.0.1:8001"
+	bindingEndpoint          = "/api/v1/namespaces/%s/pods/%s/binding/"
+	configMapEndpoint        = "/api/v1/namespaces/%s/configmaps/%s"
+	eventEndpoint            = "/api/v1/namespaces/%s/events"
+	eventsV1Group            = "/apis/events.k8s.io/v1"
+	eventV1Endpoint          = "/apis/events.k8s.io/v1/namespaces/%s/events"
+	evictionEndpoint         = "/api/v1/namespaces/%s/pods/%s/eviction"
+	jobTemplateGroup         = "/apis/pbs.altair.com/v1"
+	jobTemplateEndpoint      = "/apis/pbs.altair.com/v1/namespaces/%s/pbsjobtemplates/%s"
+	jobTemplatesEndpoint     = "/apis/pbs.altair.com/v1/pbsjobtemplates"
+	nodeEndpoint             = "/api/v1/nodes"
+	podEndpoint              = "/api/v1/pods"
+	podNamespace             = "/api/v1/namespaces/%s/pods/"
+	watchJobTemplateEndpoint = "/apis/pbs.altair.com/v1/watch/pbsjobtemplates"
+	watchPodEndpoint         = "/api/v1/watch/pods"
 )
 
-func postsEvent(event Event) error {
+// submitDelay gives PBS time to consider a newly submitted job before fit
+// looks for its node.
+var submitDelay = 5000 * time.Millisecond
+
+// postsEvent creates the event and returns it as stored by the API server.
+func postsEvent(event Event) (*Event, error) {
 	var bf []byte
 	body := bytes.NewBuffer(bf)
 	error := json.NewEncoder(body).Encode(event)
 	if error != nil {
-		return error
+		return nil, error
 	}
 
 	req :=  &http.Request{
@@ -93,7 +105,7 @@ func postsEvent(event Event) error {
 		Method:        http.MethodPost,
 		URL: &url.URL{
 			Host:   apiHost,
-			Path:   eventEndpoint,
+			Path:   fmt.Sprintf(eventEndpoint, event.InvolvedObject.Namespace),
 			Scheme: "http",
 		},
 	}
@@ -101,20 +113,157 @@ func postsEvent(event Event) error {
 
 	res, error := http.DefaultClient.Do(req)
 	if error != nil {
-		return error
+		return nil, error
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 201 {
+		return nil, errors.New("Event: Unexpected HTTP status code" + res.Status)
+	}
+	var created Event
+	error = json.NewDecoder(res.Body).Decode(&created)
+	if error != nil {
+		return nil, error
+	}
+	return &created, nil
+}
+
+// postsEventV1 creates the events.k8s.io/v1 event and returns it as
+// stored by the API server.
+func postsEventV1(event EventV1) (*EventV1, error) {
+	var bf []byte
+	body := bytes.NewBuffer(bf)
+	error := json.NewEncoder(body).Encode(event)
+	if error != nil {
+		return nil, error
+	}
+
+	req := &http.Request{
+		Body:          ioutil.NopCloser(body),
+		ContentLength: int64(body.Len()),
+		Header:        make(http.Header),
+		Method:        http.MethodPost,
+		URL: &url.URL{
+			Host:   apiHost,
+			Path:   fmt.Sprintf(eventV1Endpoint, event.Regarding.Namespace),
+			Scheme: "http",
+		},
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, error := http.DefaultClient.Do(req)
+	if error != nil {
+		return nil, error
 	}
+	defer res.Body.Close()
 	if res.StatusCode != 201 {
+		return nil, errors.New("Event: Unexpected HTTP status code" + res.Status)
+	}
+	var created EventV1
+	error = json.NewDecoder(res.Body).Decode(&created)
+	if error != nil {
+		return nil, error
+	}
+	return &created, nil
+}
+
+// apiAvailable reports whether the API server serves the given API group
+// version path, e.g. "/apis/events.k8s.io/v1".
+func apiAvailable(path string) (bool, error) {
+	req := &http.Request{
+		Header: make(http.Header),
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Host:   apiHost,
+			Path:   path,
+			Scheme: "http",
+		},
+	}
+	req.Header.Set("Accept", "application/json, */*")
+
+	res, error := http.DefaultClient.Do(req)
+	if error != nil {
+		return false, error
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	}
+	return false, errors.New("Discovery: Unexpected HTTP status code" + res.Status)
+}
+
+// patchEvent applies a merge patch to an existing event of the namespace
+// served at the given endpoint. errEventNotFound is returned when the event
+// has expired.
+func patchEvent(endpoint string, namespace string, name string, patch interface{}) error {
+	var b []byte
+	body := bytes.NewBuffer(b)
+	error := json.NewEncoder(body).Encode(patch)
+	if error != nil {
+		return error
+	}
+
+	url := "http://" + apiHost + fmt.Sprintf(endpoint, namespace) + "/" + name
+	req, error := http.NewRequest("PATCH", url, body)
+	if error != nil {
+		return error
+	}
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("Accept", "application/json, */*")
+
+	res, error := http.DefaultClient.Do(req)
+	if error != nil {
+		return error
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return errEventNotFound
+	}
+	if res.StatusCode != 200 {
 		return errors.New("Event: Unexpected HTTP status code" + res.Status)
 	}
 	return nil
 }
 
+// namespaceOf returns the namespace of the pod; pods without one live in
+// the default namespace.
+func namespaceOf(pod *Pod) string {
+	if pod.Metadata.Namespace == "" {
+		return "default"
+	}
+	return pod.Metadata.Namespace
+}
+
+// newPodEvent builds a scheduler event about the given pod.
+func newPodEvent(pod *Pod, reason string, eventType string, msg string) Event {
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	return Event{
+		Count:          1,
+		Message:        msg,
+		Metadata:       Metadata{GenerateName: pod.Metadata.Name + "-", Namespace: namespaceOf(pod)},
+		Reason:         reason,
+		Action:         eventActions[reason],
+		LastTimestamp:  timestamp,
+		FirstTimestamp: timestamp,
+		Type:           eventType,
+		Source:         EventSource{Component: "PBS-scheduler", Host: instanceName()},
+		InvolvedObject: ObjectReference{
+			Kind:      "Pod",
+			Name:      pod.Metadata.Name,
+			Namespace: namespaceOf(pod),
+			Uid:       pod.Metadata.Uid,
+		},
+	}
+}
+
 func watchUnscheduledPods() (<-chan Pod, <-chan error) {	
 	pods := make(chan Pod)
 	errc := make(chan error, 1)
 
 	val := url.Values{}
-	val.Set("fieldSelector", "spec.nodeName=")	
+	val.Set("fieldSelector", "spec.nodeName=,spec.schedulerName="+schedulerName())	
 	val.Add("sort","creationTimestamp asc")
 	req  := &http.Request{
 		Header: make(http.Header),
@@ -162,11 +311,237 @@ func watchUnscheduledPods() (<-chan Pod, <-chan error) {
 	return pods, errc
 }
 
+func getPod(namespace string, name string) (*Pod, error) {
+	var pod Pod
+
+	req := &http.Request{
+		Header: make(http.Header),
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Host:   apiHost,
+			Path:   fmt.Sprintf(podNamespace, namespace) + name,
+			Scheme: "http",
+		},
+	}
+	req.Header.Set("Accept", "application/json, */*")
+
+	res, error := http.DefaultClient.Do(req)
+	if error != nil {
+		return nil, error
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, errors.New("Pod: Unexpected HTTP status code" + res.Status)
+	}
+	error = json.NewDecoder(res.Body).Decode(&pod)
+	if error != nil {
+		return nil, error
+	}
+	return &pod, nil
+}
+
+// getConfigMap returns the ConfigMap, or nil if it does not exist.
+func getConfigMap(namespace string, name string) (*ConfigMap, error) {
+	var cm ConfigMap
+
+	req := &http.Request{
+		Header: make(http.Header),
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Host:   apiHost,
+			Path:   fmt.Sprintf(configMapEndpoint, namespace, name),
+			Scheme: "http",
+		},
+	}
+	req.Header.Set("Accept", "application/json, */*")
+
+	res, error := http.DefaultClient.Do(req)
+	if error != nil {
+		return nil, error
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return nil, nil
+	}
+	if res.StatusCode != 200 {
+		return nil, errors.New("ConfigMap: Unexpected HTTP status code" + res.Status)
+	}
+	error = json.NewDecoder(res.Body).Decode(&cm)
+	if error != nil {
+		return nil, error
+	}
+	return &cm, nil
+}
+
+var errConfigMapConflict = errors.New("ConfigMap: the object has been modified")
+
+// saveConfigMap creates the ConfigMap, or replaces it when it has a resource
+// version, and updates cm with the stored object. errConfigMapConflict is
+// returned when it was modified meanwhile.
+func saveConfigMap(cm *ConfigMap) error {
+	var b []byte
+	body := bytes.NewBuffer(b)
+	error := json.NewEncoder(body).Encode(cm)
+	if error != nil {
+		return error
+	}
+
+	method := http.MethodPut
+	path := fmt.Sprintf(configMapEndpoint, cm.Metadata.Namespace, cm.Metadata.Name)
+	if cm.Metadata.ResourceVersion == "" {
+		method = http.MethodPost
+		path = strings.TrimSuffix(fmt.Sprintf(configMapEndpoint, cm.Metadata.Namespace, ""), "/")
+	}
+	req := &http.Request{
+		Body:          ioutil.NopCloser(body),
+		ContentLength: int64(body.Len()),
+		Header:        make(http.Header),
+		Method:        method,
+		URL: &url.URL{
+			Host:   apiHost,
+			Path:   path,
+			Scheme: "http",
+		},
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, error := http.DefaultClient.Do(req)
+	if error != nil {
+		return error
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 409 {
+		return errConfigMapConflict
+	}
+	if res.StatusCode != 200 && res.StatusCode != 201 {
+		return errors.New("ConfigMap: Unexpected HTTP status code" + res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(cm)
+}
+
+// watchJobTemplates streams the PBSJobTemplate watch events of all
+// namespaces, reconnecting when the watch ends.
+func watchJobTemplates() (<-chan PBSJobTemplateWatchEvent, <-chan error) {
+	events := make(chan PBSJobTemplateWatchEvent)
+	errc := make(chan error, 1)
+
+	req := &http.Request{
+		Header: make(http.Header),
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Host:   apiHost,
+			Path:   watchJobTemplateEndpoint,
+			Scheme: "http",
+		},
+	}
+	req.Header.Set("Accept", "application/json, */*")
+
+	go func() {
+		for {
+			res, error := http.DefaultClient.Do(req)
+			if error != nil {
+				errc <- error
+				time.Sleep(5 * time.Second)
+				continue
+			}
+
+			if res.StatusCode != 200 {
+				res.Body.Close()
+				errc <- errors.New("PBSJobTemplate: Unexpected HTTP status code" + res.Status)
+				time.Sleep(5 * time.Second)
+				continue
+			}
+
+			decoder := json.NewDecoder(res.Body)
+			for {
+				var event PBSJobTemplateWatchEvent
+				error = decoder.Decode(&event)
+				if error != nil {
+					errc <- error
+					break
+				}
+				events <- event
+			}
+			res.Body.Close()
+		}
+	}()
+
+	return events, errc
+}
+
+// listJobTemplates returns the PBSJobTemplates of all namespaces.
+func listJobTemplates() (*PBSJobTemplateList, error) {
+	var list PBSJobTemplateList
+
+	req := &http.Request{
+		Header: make(http.Header),
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Host:   apiHost,
+			Path:   jobTemplatesEndpoint,
+			Scheme: "http",
+		},
+	}
+	req.Header.Set("Accept", "application/json, */*")
+
+	res, error := http.DefaultClient.Do(req)
+	if error != nil {
+		return nil, error
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, errors.New("PBSJobTemplates: Unexpected HTTP status code" + res.Status)
+	}
+	error = json.NewDecoder(res.Body).Decode(&list)
+	if error != nil {
+		return nil, error
+	}
+	return &list, nil
+}
+
+// getJobTemplate returns the PBSJobTemplate, or nil if it does not exist.
+func getJobTemplate(namespace string, name string) (*PBSJobTemplate, error) {
+	var t PBSJobTemplate
+
+	req := &http.Request{
+		Header: make(http.Header),
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Host:   apiHost,
+			Path:   fmt.Sprintf(jobTemplateEndpoint, namespace, name),
+			Scheme: "http",
+		},
+	}
+	req.Header.Set("Accept", "application/json, */*")
+
+	res, error := http.DefaultClient.Do(req)
+	if error != nil {
+		return nil, error
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return nil, nil
+	}
+	if res.StatusCode != 200 {
+		return nil, errors.New("PBSJobTemplate: Unexpected HTTP status code" + res.Status)
+	}
+	error = json.NewDecoder(res.Body).Decode(&t)
+	if error != nil {
+		return nil, error
+	}
+	return &t, nil
+}
+
+// getUnscheduledPods returns the pending pods selecting this scheduler.
 func getUnscheduledPods() (*PodList, error) {
+	return getPods("spec.nodeName=,spec.schedulerName="+schedulerName())
+}
+
+func getPods(fieldSelector string) (*PodList, error) {
 	var podList PodList	
 
 	val := url.Values{}
-	val.Set("fieldSelector", "spec.nodeName=")
+	val.Set("fieldSelector", fieldSelector)
 
 	req  := &http.Request{
 		Header: make(http.Header),
@@ -191,166 +566,194 @@ func getUnscheduledPods() (*PodList, error) {
 	return &podList, nil
 }
 
+func getNodes() (*NodeList, error) {
+	var nodeList NodeList
+
+	req := &http.Request{
+		Header: make(http.Header),
+		Method: http.MethodGet,
+		URL: &url.URL{
+			Host:   apiHost,
+			Path:   nodeEndpoint,
+			Scheme: "http",
+		},
+	}
+	req.Header.Set("Accept", "application/json, */*")
+
+	res, error := http.DefaultClient.Do(req)
+	if error != nil {
+		return nil, error
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, errors.New("Nodes: Unexpected HTTP status code" + res.Status)
+	}
+	error = json.NewDecoder(res.Body).Decode(&nodeList)
+	if error != nil {
+		return nil, error
+	}
+	return &nodeList, nil
+}
 
 func fit(pod *Pod) (string,error) {
 	
-	var spaceRequired int
-	var memoryRequired int
 	jobid := ""	
+	if subjob, ok := arraySubjob(pod); ok {
+		if subjob == "" {
+			return "", nil
+		}
+		// The copy of the pod predates its annotation, or annotating it
+		// failed: patch it again.
+		decision.job(subjob)
+		err := annotation(pod, subjob)
+		if err != nil {
+			return "Error", err
+		}
+	}
+	if pod.Metadata.Annotations["JobID"] == "" {
+		recoverJob(pod)
+	}
 	if pod.Metadata.Annotations["JobID"] == "" {
 
 		//calculate resources
 
-		for _, c := range pod.Spec.Containers {
-			milliCores := strings.TrimSuffix(c.Resources.Requests["cpu"], "m")
-			cores, err := strconv.Atoi(milliCores)
-			if err != nil {
-                        	return "Error",err
-                        }
-			spaceRequired += cores				
+		req, err := podJobRequest(pod)
+		if r, ok := err.(*rejection); ok {
+			recorder.Record(pod, "FailedScheduling", "Warning", r.msg)
+			return "Error", r
 		}
-		
-		ncpus := strconv.Itoa(spaceRequired)
-
-		for _, c := range pod.Spec.Containers {
-			if strings.HasSuffix(c.Resources.Requests["memory"], "Mi") {
-				milliCores1 := strings.TrimSuffix(c.Resources.Requests["memory"], "Mi")
-				cores1, err1 := strconv.Atoi(milliCores1)
-				if err1 != nil {
-					return "Error",err1
+		if err != nil {
+			return "Error", err
+		}
+		decision.resources(req.ncpus, req.memMB)
+		ncpus := strconv.Itoa(req.ncpus)
+		mem := strconv.Itoa(req.memMB)
+		mem = mem + "MB"
+		tmpl := req.template
+
+		array, err := podArray(pod, req)
+		if err != nil {
+			return "Error", err
+		}
+		name := jobName(pod, req.workload)
+		variables := "PODNAME="+pod.Metadata.Name
+		if array != nil {
+			name = array.name
+			variables = array.variables()
+		}
+
+		argstr := []string{"-l","select=1:ncpus=" + ncpus + ":mem="+mem+req.constraints+tmpl.chunk(),"-N",name,"-v",variables+",PODNAMESPACE="+namespaceOf(pod)+workloadVariables(req.workload)+tmpl.variables()}
+		argstr = append(argstr, array.args()...)
+		argstr = append(argstr, req.priority...)
+		argstr = append(argstr, req.policy.args(hasOption(req.priority, "-q"))...)
+		argstr = append(argstr, tmpl.args()...)
+		script, err := jobScript(pod, ncpus, mem, tmpl.Hooks, array)
+		if err != nil {
+			return "Error", err
+		}
+		sub := config.Identity.submission(argstr, script, req.user, req.group)
+		decision.submission(sub)
+		if dryRun {
+			dryRunStats.skip("qsub", commandLine(sub.command())+" < job script ("+config.JobScript.name()+")")
+			if array != nil {
+				for _, p := range array.pods {
+					arraySubjobs[p.Metadata.Uid] = ""
 				}
-				memoryRequired += cores1
 			}
-		}	
-		mem := strconv.Itoa(memoryRequired)
-		mem = mem + "MB"
+			return "", nil
+		}
+		jobid, err = pbsServer.Submit(sub)
+		if err != nil {
+			msg := fmt.Sprintf("pod (%s) could not be submitted to PBS: %v", pod.Metadata.Name, err)
+			recorder.Record(pod, "FailedScheduling", "Warning", msg)
+			return "Error", err
+		}
+		time.Sleep(submitDelay)
 
-		argstr := []string{"-l","select=1:ncpus=" + ncpus + ":mem="+mem,"-N",pod.Metadata.Name,"-v","PODNAME="+pod.Metadata.Name,"kubernetes_job.sh"}
-		out, err := exec.Command("qsub", argstr...).Output()
-	        if err != nil {
-	            log.Fatal(err)
-        	    os.Exit(1)
-        	}
-        	jobid = string(out)
-		last := len(jobid) - 1
-		jobid = jobid[0:last]
-        	time.Sleep(5000 * time.Millisecond)
-		
 		// Store jobid in pod
 
-		annotation(pod,jobid)	
+		if array != nil {
+			jobid = array.assign(jobid, pod)
+			decision.job(jobid)
+		} else {
+			decision.job(jobid)
+			recordJob(pod, jobid, jobSubmitted, "", "")
+			err = annotation(pod,jobid)
+			if err != nil {
+				arraySubjobs[pod.Metadata.Uid] = jobid
+				return "Error", err
+			}
+		}
 							    
 	} else {				
 		jobid = pod.Metadata.Annotations["JobID"] 						
 	}
 	// find a node
-	nodename := findnode(jobid)
+	nodename, err := findnode(jobid)
+	if err == errUnknownJob {
+		return "", dropLostJob(pod, jobid, "no longer exists")
+	}
+	if err != nil {
+		return "Error", err
+	}
 
 	if nodename != "" {
 		log.Println("Job Scheduled, associating node " + nodename + " to " + pod.Metadata.Name)
 		return nodename, nil
 	} 
 
-	out1, err := exec.Command("bash", "-c" ,"qstat -f " + jobid).Output()        
-        if err != nil {
-            log.Fatal(err)
-            os.Exit(1)
-        }
-	comment := string(out1)
- 	splits := strings.Split(comment, "\n")	
-	i := 0
-	for i >= 0{
-            if strings.Contains(splits[i], "comment") {
-                break;
-            }
-            i++;
-        }	
-	log.Println(pod.Metadata.Name + ":" + splits[i])	 
-
-	timestamp := time.Now().UTC().Format(time.RFC3339)
-	event := Event{
-		Count:          1,
-		Message:        fmt.Sprintf("pod (%s) failed to fit in any node\n", pod.Metadata.Name),
-		Metadata:       Metadata{GenerateName: pod.Metadata.Name + "-"},
-		Reason:         "FailedScheduling",
-		LastTimestamp:  timestamp,
-		FirstTimestamp: timestamp,
-		Type:           "Warning",
-		Source:         EventSource{Component: "PBS-scheduler"},
-		InvolvedObject: ObjectReference{
-			Kind:      "Pod",
-			Name:      pod.Metadata.Name,
-			Namespace: "default",
-			Uid:       pod.Metadata.Uid,
-		},
+	status, err := pbsServer.Status(jobid)
+	if err == errUnknownJob {
+		return "", dropLostJob(pod, jobid, "no longer exists")
+	}
+	if err != nil {
+		return "Error", err
+	}
+	if status.State == "F" || status.State == "X" {
+		return "", dropLostJob(pod, jobid, "has finished")
+	}
+	log.Println(pod.Metadata.Name + ": comment = " + status.Comment)
+	err = reflectJobStatus(pod, status)
+	if err != nil {
+		log.Println(err)
 	}
 
-	postsEvent(event)		
+	msg := fmt.Sprintf("pod (%s) failed to fit in any node", pod.Metadata.Name)
+	if status.Comment != "" {
+		msg = fmt.Sprintf("pod (%s) is waiting for PBS job %s: %s", pod.Metadata.Name, jobid, status.Comment)
+	}
+	recorder.Record(pod, "FailedScheduling", "Warning", msg)
 	
 	return "",nil
-	
-	
 }
 
 
-func findnode(jobid string) string {
-
-	returnstring := ""
-
-        out1, err := exec.Command("bash", "-c" ,"qstat -f " + jobid).Output()        
-        if err != nil {
-            log.Fatal(err)
-            os.Exit(1)
-        }
-	nodevalue := string(out1)
- 	splits := strings.Split(nodevalue, " ")	
-	flag1 := "job_state"
-	flag2 := "substate"
-	i := 0
-	for i >= 0{
-            if splits[i] == flag1 {
-                break;
-            }
-            i++;
-        }
-	
-	j := 0
-	for j >= 0{
-            if splits[j] == flag2 {
-                break;
-            }
-            j++;
-        }
-	job_state := splits[i+2]
-	last1 := len(job_state) - 1		
-
-	substate := splits[j+2]
-	last2 := len(substate) - 1	
-	
-	if job_state[0:last1] == "R" && substate[0:last2] == "42" {	
-	    log.Println("Finding node")
-	    word := "exec_host"
-            i = 0
-            for i >= 0{
-                if splits[i] == word {
-                    break;
-                }
-                i++;
-            }
-	    nodename := splits[i+2]
-	    returnstring = strings.SplitAfter(nodename, "/")[0]
-	    if returnstring[len(returnstring) - 1:len(returnstring)] == "/" {
-	        last := len(returnstring) - 1
-	        returnstring = returnstring[0:last]
-	    }
+func findnode(jobid string) (string, error) {
+	status, err := pbsServer.Status(jobid)
+	if err != nil {
+		return "", err
 	}
-	
-	return returnstring
+	decision.snapshot(status)
+	if status.State == "R" && (status.Substate == "42" || startedJobs[jobid] && status.ExecNode() != "") {
+		log.Println("Finding node")
+		return status.ExecNode(), nil
+	}
+	return "", nil
+}
+
+// dropLostJob clears the job of a pending pod whose PBS job was deleted or
+// finished without running it, so that the pod is submitted again.
+func dropLostJob(pod *Pod, jobid string, reason string) error {
+	msg := fmt.Sprintf("PBS job %s of pod (%s) %s, submitting the pod again", jobid, pod.Metadata.Name, reason)
+	log.Println(msg)
+	recorder.Record(pod, "FailedScheduling", "Warning", msg)
+	delete(arraySubjobs, pod.Metadata.Uid)
+	return clearJob(pod, nil)
 }
 
 
 
-func annotation(pod *Pod, jobid string) {		
+func annotation(pod *Pod, jobid string) error {		
 					
 	annotations := map[string]string{
 		"JobID": jobid,
@@ -360,45 +763,67 @@ func annotation(pod *Pod, jobid string) {
 			Annotations: annotations,
 		},
 	}
+
+	error := patchPod(pod, "", patch)
+	if error != nil {
+		return error
+	}
+	if pod.Metadata.Annotations == nil {
+		pod.Metadata.Annotations = map[string]string{}
+	}
+	pod.Metadata.Annotations["JobID"] = jobid
 	
+	log.Println("Associating Jobid " + jobid + " to pod " + pod.Metadata.Name)
+	return nil
+}
+
+// patchPod applies a strategic merge patch to the pod, or to one of its
+// subresources such as "status".
+func patchPod(pod *Pod, subresource string, patch interface{}) error {
 	var b []byte
 	body := bytes.NewBuffer(b)
 	error := json.NewEncoder(body).Encode(patch)
 	if error != nil {
-		log.Println(error)
-		os.Exit(1)
+		return error
+	}
+	if dryRun {
+		dryRunStats.skip("pod patch", fmt.Sprintf("patch pod %s %s: %s", pod.Metadata.Name, subresource, strings.TrimSpace(body.String())))
+		return nil
+	}
+
+	url := "http://" + apiHost + fmt.Sprintf(podNamespace, namespaceOf(pod)) + pod.Metadata.Name
+	if subresource != "" {
+		url += "/" + subresource
 	}
-	
-	url := "http://" + apiHost + podNamespace + pod.Metadata.Name
 	req, error := http.NewRequest("PATCH", url, body)
 	if error != nil {
-		log.Println(error)
-		os.Exit(1)
+		return error
 	}
-	
+
 	req.Header.Set("Content-Type", "application/strategic-merge-patch+json")
 	req.Header.Set("Accept", "application/json, */*")
-	
+
 	res, error := http.DefaultClient.Do(req)
 	if error != nil {
-		log.Println(error)
-		os.Exit(1)
-	}					
+		return error
+	}
+	defer res.Body.Close()
 	if res.StatusCode != 200 {
-		log.Println(error)
-		os.Exit(1)
+		return errors.New("Patch: Unexpected HTTP status code" + res.Status)
 	}
-	
-	log.Println("Associating Jobid " + jobid + " to pod " + pod.Metadata.Name)
-
+	return nil
 }
 
 
 func bind(pod *Pod, node string) error {
+	if dryRun {
+		dryRunStats.skip("binding", fmt.Sprintf("bind pod %s to node %s", pod.Metadata.Name, node))
+		return nil
+	}
 	bindreq := Binding{
 		ApiVersion: "v1",
 		Kind:       "Binding",
-		Metadata:   Metadata{Name: pod.Metadata.Name},
+		Metadata:   Metadata{Name: pod.Metadata.Name, Namespace: namespaceOf(pod)},
 		Target: Target{
 			ApiVersion: "v1",
 			Kind:       "Node",
@@ -420,7 +845,7 @@ func bind(pod *Pod, node string) error {
 		Method:        http.MethodPost,
 		URL: &url.URL{
 			Host:   apiHost,
-			Path:   fmt.Sprintf(bindingEndpoint, pod.Metadata.Name),
+			Path:   fmt.Sprintf(bindingEndpoint, namespaceOf(pod), pod.Metadata.Name),
 			Scheme: "http",
 		},
 	}
@@ -433,26 +858,59 @@ func bind(pod *Pod, node string) error {
 	if res.StatusCode != 201 {
 		return errors.New("Binding: Unexpected HTTP status code" + res.Status)
 	}
+	pod.Spec.NodeName = node
+	recordJob(pod, pod.Metadata.Annotations["JobID"], jobBound, "", node)
 
 	// Shoot a Kubernetes event that the Pod was scheduled successfully.
 	msg := fmt.Sprintf("Successfully assigned %s to %s", pod.Metadata.Name, node)
-	timestamp := time.Now().UTC().Format(time.RFC3339)
-	event := Event{
-		Count:          1,
-		Message:        msg,
-		Metadata:       Metadata{GenerateName: pod.Metadata.Name + "-"},
-		Reason:         "Scheduled",
-		LastTimestamp:  timestamp,
-		FirstTimestamp: timestamp,
-		Type:           "Normal",
-		Source:         EventSource{Component: "PBS-scheduler"},
-		InvolvedObject: ObjectReference{
-			Kind:      "Pod",
-			Name:      pod.Metadata.Name,
-			Namespace: "default",
-			Uid:       pod.Metadata.Uid,
+	log.Println(msg)
+	return recorder.Record(pod, "Scheduled", "Normal", msg)
+}
+
+// evict asks the API server to evict the pod. Evictions honor the
+// PodDisruptionBudgets covering the pod; errEvictionBlocked is returned
+// when a budget does not allow the disruption right now.
+func evict(pod *Pod) error {
+	if dryRun {
+		dryRunStats.skip("eviction", "evict pod "+pod.Metadata.Name)
+		return nil
+	}
+	eviction := Eviction{
+		ApiVersion: "policy/v1",
+		Kind:       "Eviction",
+		Metadata:   Metadata{Name: pod.Metadata.Name, Namespace: namespaceOf(pod)},
+	}
+
+	var b []byte
+	body := bytes.NewBuffer(b)
+	error := json.NewEncoder(body).Encode(eviction)
+	if error != nil {
+		return error
+	}
+
+	req := &http.Request{
+		Body:          ioutil.NopCloser(body),
+		ContentLength: int64(body.Len()),
+		Header:        make(http.Header),
+		Method:        http.MethodPost,
+		URL: &url.URL{
+			Host:   apiHost,
+			Path:   fmt.Sprintf(evictionEndpoint, namespaceOf(pod), pod.Metadata.Name),
+			Scheme: "http",
 		},
 	}
-	log.Println(msg)
-	return postsEvent(event)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, error := http.DefaultClient.Do(req)
+	if error != nil {
+		return error
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case 200, 201:
+		return nil
+	case 429:
+		return errEvictionBlocked
+	}
+	return errors.New("Eviction: Unexpected HTTP status code" + res.Status)
 }
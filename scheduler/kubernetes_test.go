@@ -0,0 +1,188 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBind(t *testing.T) {
+	c := newTestCluster(t, "node1")
+	pod := testPod("web", "1", "100Mi")
+	c.api.AddPod("default", pod)
+	pod = c.pod(t, "web")
+
+	err := bind(&pod, "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.pod(t, "web").Spec.NodeName; got != "node1" {
+		t.Errorf("pod bound to %q, want node1", got)
+	}
+	err = bind(&pod, "node1")
+	if err == nil {
+		t.Error("binding a bound pod succeeded")
+	}
+}
+
+func TestAnnotation(t *testing.T) {
+	c := newTestCluster(t)
+	pod := testPod("web", "1", "100Mi")
+	c.api.AddPod("default", pod)
+	pod = c.pod(t, "web")
+
+	err := annotation(&pod, "12.server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pod.Metadata.Annotations["JobID"]; got != "12.server" {
+		t.Errorf("local JobID = %q, want 12.server", got)
+	}
+	if got := c.pod(t, "web").Metadata.Annotations["JobID"]; got != "12.server" {
+		t.Errorf("stored JobID = %q, want 12.server", got)
+	}
+
+	missing := testPod("gone", "1", "100Mi")
+	missing.Metadata.Namespace = "default"
+	err = annotation(&missing, "13.server")
+	if err == nil {
+		t.Error("annotating a missing pod succeeded")
+	}
+}
+
+func TestWatchUnscheduledPods(t *testing.T) {
+	c := newTestCluster(t)
+	other := testPod("other", "1", "100Mi")
+	other.Spec.SchedulerName = "default-scheduler"
+	c.api.AddPod("default", other)
+	c.api.AddPod("default", testPod("web", "1", "100Mi"))
+
+	pods, _ := watchUnscheduledPods()
+	select {
+	case pod := <-pods:
+		if pod.Metadata.Name != "web" {
+			t.Errorf("watched pod %s, want web", pod.Metadata.Name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no pod watched")
+	}
+	select {
+	case pod := <-pods:
+		t.Errorf("watched pod %s of another scheduler", pod.Metadata.Name)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSchedulePodSubmitsThenBinds(t *testing.T) {
+	c := newTestCluster(t, "node1")
+	c.api.AddPod("default", testPod("web", "2", "512Mi"))
+	pod := c.pod(t, "web")
+
+	err := schedulePod(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobid := c.pod(t, "web").Metadata.Annotations["JobID"]
+	if jobid == "" {
+		t.Fatal("pod not annotated with its job")
+	}
+	status, err := c.pbs.Status(jobid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Attributes["Resource_List.ncpus"] != "2" {
+		t.Errorf("job requests %s cpus, want 2", status.Attributes["Resource_List.ncpus"])
+	}
+
+	// The job starts running (41) in one cycle and executes (42) in the next.
+	c.pbs.Schedule()
+	c.pbs.Schedule()
+	pod = c.pod(t, "web")
+	err = schedulePod(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.pod(t, "web").Spec.NodeName; got != "node1" {
+		t.Errorf("pod bound to %q, want node1", got)
+	}
+}
+
+func TestSchedulePodSubmitError(t *testing.T) {
+	c := newTestCluster(t, "node1")
+	config.PriorityClasses = []PriorityMapping{{PriorityClassName: "batch", Queue: "missing"}}
+	pod := testPod("web", "1", "100Mi")
+	pod.Spec.PriorityClassName = "batch"
+	c.api.AddPod("default", pod)
+	pod = c.pod(t, "web")
+
+	err := schedulePod(&pod)
+	if err == nil {
+		t.Fatal("submission to an unknown queue succeeded")
+	}
+	if c.pod(t, "web").Metadata.Annotations["JobID"] != "" {
+		t.Error("pod annotated with a job")
+	}
+	found := false
+	for _, e := range c.api.Events() {
+		if e.Reason == "FailedScheduling" && strings.Contains(e.Message, "could not be submitted") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("no FailedScheduling event recorded")
+	}
+}
+
+func TestSchedulePodDropsLostJob(t *testing.T) {
+	c := newTestCluster(t, "node1")
+	pod := testPod("web", "1", "100Mi")
+	pod.Metadata.Annotations = map[string]string{"JobID": "99.fakepbs"}
+	c.api.AddPod("default", pod)
+	pod = c.pod(t, "web")
+
+	err := schedulePod(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobid := c.pod(t, "web").Metadata.Annotations["JobID"]; jobid != "" {
+		t.Errorf("JobID of the lost job = %q, want cleared", jobid)
+	}
+}
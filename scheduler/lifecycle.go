@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+// jobExitAnnotation publishes the exit status of a terminated pod to its
+// job script, which exits with it.
+const jobExitAnnotation = "JobExitStatus"
+
+// terminatedAt records when the scheduler first saw the pod of a running job
+// terminated, by job id. It is only used with processLock held.
+var terminatedAt = map[string]time.Time{}
+
+// podExitStatus returns the exit status of a terminated pod: the first non
+// zero exit code of its containers, 1 for a failed pod without one, or 0.
+func podExitStatus(pod *Pod) int {
+	for _, c := range pod.Status.ContainerStatuses {
+		if c.State.Terminated != nil && c.State.Terminated.ExitCode != 0 {
+			return c.State.Terminated.ExitCode
+		}
+	}
+	if pod.Status.Phase == "Failed" {
+		return 1
+	}
+	return 0
+}
+
+// finishJob ends the PBS job of a terminated pod. The exit status of the
+// pod is published on the pod for the job script to exit with, so that PBS
+// accounting reflects the result of the pod; a job still running after the
+// exit grace period is deleted. A pod whose job PBS no longer knows is
+// marked finished so that it is not looked up again. The end of the job is
+// recorded in the state store.
+func finishJob(pod *Pod, status *JobStatus) error {
+	jobid := pod.Metadata.Annotations["JobID"]
+	if status == nil {
+		delete(terminatedAt, jobid)
+		patch := map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{jobStateAnnotation: "F"},
+			},
+		}
+		err := patchPod(pod, "", patch)
+		if err != nil {
+			return err
+		}
+		recordJob(pod, jobid, jobFinished, "F", "")
+		return nil
+	}
+	if status.State == "F" || status.State == "X" {
+		delete(terminatedAt, jobid)
+		recordJob(pod, jobid, jobFinished, status.State, "")
+		return nil
+	}
+	if status.State != "R" && status.State != "S" && status.State != "U" {
+		delete(terminatedAt, jobid)
+		return nil
+	}
+
+	if _, ok := pod.Metadata.Annotations[jobExitAnnotation]; !ok {
+		exit := strconv.Itoa(podExitStatus(pod))
+		patch := map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{jobExitAnnotation: exit},
+			},
+		}
+		err := patchPod(pod, "", patch)
+		if err != nil {
+			return err
+		}
+		log.Printf("Pod %s terminated with exit status %s, ending PBS job %s", pod.Metadata.Name, exit, jobid)
+	}
+
+	first, ok := terminatedAt[jobid]
+	if !ok {
+		terminatedAt[jobid] = time.Now()
+		return nil
+	}
+	grace := config.JobScript.ExitGrace
+	if grace <= 0 {
+		grace = 60
+	}
+	if time.Since(first) < time.Duration(grace)*time.Second {
+		return nil
+	}
+	if dryRun {
+		dryRunStats.skip("qdel", "qdel "+jobid)
+		return nil
+	}
+	err := pbsServer.Delete(jobid)
+	if err != nil && err != errUnknownJob {
+		return err
+	}
+	delete(terminatedAt, jobid)
+	log.Printf("PBS job %s did not exit after pod %s terminated, deleted it", jobid, pod.Metadata.Name)
+	return nil
+}
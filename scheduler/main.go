@@ -40,25 +40,102 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 )
 
+// commands are the subcommands of the scheduler; without one, the
+// scheduler runs.
+var commands = map[string]func([]string) error{
+	"status":   runStatus,
+	"resubmit": runResubmit,
+	"release":  runRelease,
+	"detach":   runDetach,
+	"history":  runHistory,
+	"audit":    runAudit,
+}
 
 func main() {	
 
+	configPath := flag.String("config", "", "path to the scheduler JSON config file")
+	flag.BoolVar(&dryRun, "dry-run", false, "log the qsub commands, bindings and evictions instead of performing them")
+	flag.Parse()
+
+	if *configPath != "" {
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config = cfg
+	}
+	store, err := newStateStore(config.StateStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stateStore = store
+	if interval := statusInterval(); interval > 0 {
+		pbsServer = newStatusPoller(pbsServer, interval)
+	}
+
+	if flag.NArg() > 0 {
+		command, ok := commands[flag.Arg(0)]
+		if !ok {
+			log.Fatalf("unknown command %q", flag.Arg(0))
+		}
+		err := command(flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	sink, err := newEventSink(config.EventAPI)
+	if err != nil {
+		log.Fatal(err)
+	}
+	recorder = newEventRecorder(sink, time.Minute)
+
+	if config.Audit.Path != "" {
+		auditLog, err = openAuditLog(config.Audit)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	channel := make(chan struct{})
 	var wait sync.WaitGroup
 
+	if config.Notify.Listen != "" {
+		listener, err := notifyListener(config.Notify.Listen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		wait.Add(1)
+		go serveNotifications(listener, config.Notify.Token, channel, &wait)
+	}
+
+	if config.Webhook.Listen != "" {
+		wait.Add(1)
+		go serveWebhooks(config.Webhook, channel, &wait)
+	}
+
+	wait.Add(1)
+	go trackJobTemplates(channel, &wait)
+
 	wait.Add(1)
 	go trackUnscheduledPods(channel, &wait)
 
 	wait.Add(1)
 	go resolveUnscheduledPods(20, channel, &wait)
 
+	wait.Add(1)
+	go trackBoundPods(20, channel, &wait)
+
 	signalch := make(chan os.Signal, 1)
 	signal.Notify(signalch, syscall.SIGINT, syscall.SIGTERM)
 	for {
@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// jsonPatchOp is an operation of a JSON patch (RFC 6902).
+type jsonPatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// schedulerName returns the name pods use to select this scheduler.
+func schedulerName() string {
+	if config.SchedulerName == "" {
+		return "pbs-scheduler"
+	}
+	return config.SchedulerName
+}
+
+// escapePointer escapes a key for use in a JSON pointer.
+func escapePointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
+
+// mutatePodAdmission selects this scheduler for the pod and sets the PBS
+// annotations the pod does not set to the namespace policy. The webhook
+// configuration limits it to the namespaces that opted in.
+func mutatePodAdmission(req *AdmissionRequest) *AdmissionResponse {
+	res := &AdmissionResponse{Allowed: true}
+	if req.Operation != "CREATE" {
+		return res
+	}
+	pod := admissionPod(req)
+	if pod.Metadata.Annotations[managedAnnotation] == "false" {
+		return res
+	}
+
+	var patch []jsonPatchOp
+	if pod.Spec.SchedulerName != schedulerName() {
+		patch = append(patch, jsonPatchOp{"add", "/spec/schedulerName", schedulerName()})
+	}
+
+	policy, err := namespacePolicy(namespaceOf(pod))
+	if err != nil {
+		log.Println(err)
+		res.Warnings = append(res.Warnings, "PBS scheduler could not read the namespace policy: "+err.Error())
+		policy = &NamespacePolicy{}
+	}
+	defaults := map[string]string{}
+	for _, d := range []struct{ key, value string }{
+		{queueAnnotation, policy.Queue},
+		{walltimeAnnotation, policy.Walltime},
+		{projectAnnotation, policy.Project},
+	} {
+		if _, ok := pod.Metadata.Annotations[d.key]; ok || d.value == "" {
+			continue
+		}
+		defaults[d.key] = d.value
+		if pod.Metadata.Annotations != nil {
+			patch = append(patch, jsonPatchOp{"add", "/metadata/annotations/" + escapePointer(d.key), d.value})
+		}
+	}
+	if len(defaults) > 0 && pod.Metadata.Annotations == nil {
+		patch = append(patch, jsonPatchOp{"add", "/metadata/annotations", defaults})
+	}
+
+	if len(patch) == 0 {
+		return res
+	}
+	res.Patch, err = json.Marshal(patch)
+	if err != nil {
+		log.Println(err)
+		return res
+	}
+	res.PatchType = "JSONPatch"
+	return res
+}
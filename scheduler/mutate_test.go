@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMutatePodAdmission(t *testing.T) {
+	c := newTestCluster(t)
+	config.Policy = PolicyConfig{Namespace: "kube-system", ConfigMap: "pbs-policy"}
+	c.api.AddConfigMap("kube-system", ConfigMap{
+		Metadata: Metadata{Name: "pbs-policy"},
+		Data:     map[string]string{"team": `{"queue": "teamq", "walltime": "01:00:00"}`},
+	})
+	pod := testPod("web", "1", "100Mi")
+	pod.Spec.SchedulerName = ""
+	pod.Metadata.Annotations = map[string]string{walltimeAnnotation: "00:10:00"}
+
+	res := mutatePodAdmission(&AdmissionRequest{Namespace: "team", Operation: "CREATE", Object: pod})
+	if !res.Allowed || res.PatchType != "JSONPatch" {
+		t.Fatalf("response %+v, want an allowed JSON patch", res)
+	}
+	var patch []jsonPatchOp
+	err := json.Unmarshal(res.Patch, &patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]interface{}{}
+	for _, op := range patch {
+		got[op.Path] = op.Value
+	}
+	want := map[string]interface{}{
+		"/spec/schedulerName":            schedulerName(),
+		"/metadata/annotations/PBSQueue": "teamq",
+	}
+	if len(got) != len(want) {
+		t.Errorf("patch %v, want %v", got, want)
+	}
+	for path, value := range want {
+		if got[path] != value {
+			t.Errorf("patch of %s = %v, want %v", path, got[path], value)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// notifyPath is the endpoint PBS hooks call when a job starts.
+const notifyPath = "/jobs/started"
+
+// NotifyConfig enables the endpoint PBS hooks notify when a job starts, so
+// that its pod is bound without waiting for the next scheduling pass.
+type NotifyConfig struct {
+	// Listen is the address of the endpoint, "host:port" or "unix:/path".
+	Listen string `json:"listen"`
+	// Token must be sent by the hooks as a bearer token. It is required
+	// on TCP addresses; unix sockets are restricted by their permissions.
+	Token string `json:"token"`
+}
+
+func (c *NotifyConfig) validate() error {
+	if c.Listen != "" && !strings.HasPrefix(c.Listen, "unix:") && c.Token == "" {
+		return fmt.Errorf("token is required to listen on %s", c.Listen)
+	}
+	return nil
+}
+
+// JobNotification is the body of the requests of the PBS hooks.
+type JobNotification struct {
+	JobID string `json:"job_id"`
+	// Node is the node the job runs on, as reported by the hook. It is
+	// only logged: the pod is bound to the node of the job status.
+	Node string `json:"node"`
+}
+
+// notifyListener listens on the address of the endpoint. A unix socket left
+// by a previous run is replaced, and only its owner and group can connect.
+func notifyListener(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, "unix:") {
+		return net.Listen("tcp", addr)
+	}
+	path := strings.TrimPrefix(addr, "unix:")
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0660)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// serveNotifications serves the notifications of the PBS hooks until done
+// is closed.
+func serveNotifications(l net.Listener, token string, done chan struct{}, wg *sync.WaitGroup) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(notifyPath, func(w http.ResponseWriter, r *http.Request) {
+		handleJobStarted(w, r, token)
+	})
+	server := &http.Server{Handler: mux}
+	go func() {
+		<-done
+		server.Close()
+	}()
+	err := server.Serve(l)
+	if err != http.ErrServerClosed {
+		log.Println(err)
+	}
+	wg.Done()
+	log.Println("Stopped job notifications.")
+}
+
+func handleJobStarted(w http.ResponseWriter, r *http.Request, token string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var n JobNotification
+	err := json.NewDecoder(r.Body).Decode(&n)
+	if err != nil || n.JobID == "" {
+		http.Error(w, "job_id is required", http.StatusBadRequest)
+		return
+	}
+
+	processLock.Lock()
+	defer processLock.Unlock()
+	pod, err := pendingPodOfJob(n.JobID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if pod == nil {
+		http.Error(w, "no pending pod for job "+n.JobID, http.StatusNotFound)
+		return
+	}
+	if poller, ok := pbsServer.(*statusPoller); ok {
+		poller.forget(n.JobID)
+	}
+
+	log.Printf("PBS job %s started on %s, scheduling pod %s", n.JobID, n.Node, pod.Metadata.Name)
+	startedJobs[n.JobID] = true
+	defer delete(startedJobs, n.JobID)
+	err = schedulePod(pod)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if pod.Spec.NodeName == "" {
+		http.Error(w, "PBS job "+n.JobID+" is not running, pod "+pod.Metadata.Name+" not bound", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// startedJobs are the jobs whose start is being notified. The server
+// reports them running before their substate reaches 42, so their pods
+// are bound as soon as the job is in state R with an exec_host.
+var startedJobs = map[string]bool{}
+
+// pendingPodOfJob returns the unscheduled pod of a PBS job, or nil.
+func pendingPodOfJob(jobid string) (*Pod, error) {
+	pods, err := getUnscheduledPods()
+	if err != nil {
+		return nil, err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Metadata.Annotations[managedAnnotation] == "false" {
+			continue
+		}
+		subjob, _ := arraySubjob(pod)
+		if pod.Metadata.Annotations["JobID"] == jobid || subjob == jobid {
+			return pod, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleJobStartedBindsToJobNode(t *testing.T) {
+	c := newTestCluster(t, "node1", "node2")
+	c.api.AddPod("default", testPod("web", "1", "100Mi"))
+	pod := c.pod(t, "web")
+	err := schedulePod(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobid := c.pod(t, "web").Metadata.Annotations["JobID"]
+	notify := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, notifyPath, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer s3cret")
+		w := httptest.NewRecorder()
+		handleJobStarted(w, r, "s3cret")
+		return w
+	}
+	if w := notify(`{"job_id": "` + jobid + `"}`); w.Code != http.StatusConflict {
+		t.Errorf("status for a queued job = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	c.pbs.Schedule()
+	status, err := c.pbs.Status(jobid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != "R" || status.Substate != "41" {
+		t.Fatalf("job in state %s/%s, want R/41 at execjob_begin", status.State, status.Substate)
+	}
+	node := status.ExecNode()
+	other := "node1"
+	if node == other {
+		other = "node2"
+	}
+
+	body := `{"job_id": "` + jobid + `", "node": "` + other + `"}`
+	w := httptest.NewRecorder()
+	handleJobStarted(w, httptest.NewRequest(http.MethodPost, notifyPath, strings.NewReader(body)), "s3cret")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status without token = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w = notify(body)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	if got := c.pod(t, "web").Spec.NodeName; got != node {
+		t.Errorf("pod bound to %q, want the job node %s", got, node)
+	}
+}
+
+func TestNotifyConfigValidate(t *testing.T) {
+	tests := []struct {
+		config NotifyConfig
+		valid  bool
+	}{
+		{NotifyConfig{}, true},
+		{NotifyConfig{Listen: "unix:/run/pbs-scheduler.sock"}, true},
+		{NotifyConfig{Listen: "0.0.0.0:8090"}, false},
+		{NotifyConfig{Listen: "0.0.0.0:8090", Token: "s3cret"}, true},
+	}
+	for _, tt := range tests {
+		err := tt.config.validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("validate(%+v) = %v, want valid %v", tt.config, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PBS is the connector's access to the PBS server.
+type PBS interface {
+	// Submit submits a job with qsub and returns the job id.
+	Submit(sub Submission) (string, error)
+	// Status returns the full status of a job, including finished jobs when
+	// job history is enabled. errUnknownJob is returned for unknown jobs.
+	Status(jobid string) (*JobStatus, error)
+	// Delete deletes a job, as qdel does.
+	Delete(jobid string) error
+	// Release releases the holds of a job, as qrls does.
+	Release(jobid string) error
+	// StatusJobs returns the status of the given jobs in one query, as
+	// Status does. Unknown jobs are left out.
+	StatusJobs(jobids []string) ([]*JobStatus, error)
+	// Queues returns the queues of the server, as qstat -Q -f does.
+	Queues() ([]*PBSObject, error)
+	// Nodes returns the vnodes of the server, as pbsnodes -a does.
+	Nodes() ([]*PBSObject, error)
+}
+
+// PBSObject is a queue or vnode of the PBS server with its attributes,
+// flattened as in JobStatus.
+type PBSObject struct {
+	Name       string
+	Attributes map[string]string
+}
+
+var errUnknownJob = errors.New("PBS: unknown job id")
+
+// Submission is a job to submit.
+type Submission struct {
+	// Args are the qsub options.
+	Args []string
+	// Script is the job script, passed to qsub on its standard input.
+	Script string
+	// User, and optionally Group, submit the job on behalf of another user
+	// with sudo.
+	User  string
+	Group string
+}
+
+// pbsServer is the PBS implementation used by the scheduler.
+var pbsServer PBS = pbsCommands{}
+
+// JobStatus is the parsed output of qstat -f for a single job.
+type JobStatus struct {
+	ID       string
+	Name     string
+	State    string
+	Substate string
+	Queue    string
+	Comment  string
+	ExecHost string
+	// EstimatedStartTime is set by the PBS scheduler for jobs it plans to
+	// run, when estimation is enabled.
+	EstimatedStartTime string
+	Attributes         map[string]string
+}
+
+// ExecNode returns the first execution host of the job, or "" if the job is
+// not running anywhere.
+func (s *JobStatus) ExecNode() string {
+	host := strings.Split(s.ExecHost, "+")[0]
+	return strings.Split(host, "/")[0]
+}
+
+// pbsCommands implements PBS with the PBS client commands.
+type pbsCommands struct{}
+
+// command returns the command line running the submission.
+func (s Submission) command() (string, []string) {
+	if s.User == "" {
+		return "qsub", s.Args
+	}
+	sudo := []string{"-n", "-u", s.User}
+	if s.Group != "" {
+		sudo = append(sudo, "-g", s.Group)
+	}
+	return "sudo", append(append(sudo, "qsub"), s.Args...)
+}
+
+func (pbsCommands) Submit(sub Submission) (string, error) {
+	name, args := sub.command()
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(sub.Script)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", commandError("qsub", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (pbsCommands) Status(jobid string) (*JobStatus, error) {
+	out, err := exec.Command("qstat", "-f", "-x", jobid).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok && bytes.Contains(ee.Stderr, []byte("Unknown Job Id")) {
+			return nil, errUnknownJob
+		}
+		return nil, commandError("qstat", err)
+	}
+	statuses := parseQstat(out)
+	if len(statuses) == 0 {
+		return nil, errUnknownJob
+	}
+	return statuses[0], nil
+}
+
+// maxQstatJobs bounds the job ids passed to one qstat command.
+const maxQstatJobs = 500
+
+func (pbsCommands) StatusJobs(jobids []string) ([]*JobStatus, error) {
+	var statuses []*JobStatus
+	for len(jobids) > 0 {
+		n := len(jobids)
+		if n > maxQstatJobs {
+			n = maxQstatJobs
+		}
+		args := append([]string{"-f", "-F", "json", "-x", "-t"}, jobids[:n]...)
+		jobids = jobids[n:]
+		out, err := exec.Command("qstat", args...).Output()
+		if err != nil {
+			// qstat fails when some of the jobs are unknown, but still
+			// reports the others.
+			ee, ok := err.(*exec.ExitError)
+			if !ok || !bytes.Contains(ee.Stderr, []byte("Unknown Job Id")) {
+				return nil, commandError("qstat", err)
+			}
+			if len(bytes.TrimSpace(out)) == 0 {
+				continue
+			}
+		}
+		s, err := parseQstatJSON(out)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, s...)
+	}
+	return statuses, nil
+}
+
+func (pbsCommands) Queues() ([]*PBSObject, error) {
+	out, err := exec.Command("qstat", "-Q", "-f", "-F", "json").Output()
+	if err != nil {
+		return nil, commandError("qstat", err)
+	}
+	return parsePBSObjects(out, "Queue")
+}
+
+func (pbsCommands) Nodes() ([]*PBSObject, error) {
+	out, err := exec.Command("pbsnodes", "-a", "-F", "json").Output()
+	if err != nil {
+		return nil, commandError("pbsnodes", err)
+	}
+	return parsePBSObjects(out, "nodes")
+}
+
+func (pbsCommands) Delete(jobid string) error {
+	return runPBSCommand("qdel", jobid)
+}
+
+func (pbsCommands) Release(jobid string) error {
+	return runPBSCommand("qrls", jobid)
+}
+
+// runPBSCommand runs a PBS command on a job, mapping unknown job errors to
+// errUnknownJob.
+func runPBSCommand(name string, jobid string) error {
+	_, err := exec.Command(name, jobid).Output()
+	if err == nil {
+		return nil
+	}
+	if ee, ok := err.(*exec.ExitError); ok && bytes.Contains(ee.Stderr, []byte("Unknown Job Id")) {
+		return errUnknownJob
+	}
+	return commandError(name, err)
+}
+
+// commandError adds the standard error output of a failed command to err.
+func commandError(name string, err error) error {
+	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+		return errors.New(name + ": " + strings.TrimSpace(string(ee.Stderr)))
+	}
+	return errors.New(name + ": " + err.Error())
+}
+
+// parseQstat parses the output of qstat -f. Long attribute values are
+// wrapped by qstat onto continuation lines starting with a tab.
+func parseQstat(out []byte) []*JobStatus {
+	var statuses []*JobStatus
+	var current *JobStatus
+	key := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "Job Id:"):
+			current = &JobStatus{
+				ID:         strings.TrimSpace(strings.TrimPrefix(line, "Job Id:")),
+				Attributes: map[string]string{},
+			}
+			statuses = append(statuses, current)
+			key = ""
+		case current == nil:
+		case strings.HasPrefix(line, "\t") && key != "":
+			current.Attributes[key] += strings.TrimPrefix(line, "\t")
+		case strings.Contains(line, " = "):
+			kv := strings.SplitN(strings.TrimSpace(line), " = ", 2)
+			key = kv[0]
+			current.Attributes[key] = kv[1]
+		}
+	}
+	for _, s := range statuses {
+		s.setFields()
+	}
+	return statuses
+}
+
+// parseQstatJSON parses the output of qstat -f -F json. Attributes are
+// flattened as in the text output: resources become "Resource_List.ncpus"
+// and Variable_List becomes a comma separated list.
+func parseQstatJSON(out []byte) ([]*JobStatus, error) {
+	var qstat struct {
+		Jobs map[string]map[string]interface{} `json:"Jobs"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	dec.UseNumber()
+	err := dec.Decode(&qstat)
+	if err != nil {
+		return nil, fmt.Errorf("qstat: %v", err)
+	}
+	var statuses []*JobStatus
+	for id, attrs := range qstat.Jobs {
+		s := &JobStatus{ID: id, Attributes: map[string]string{}}
+		for key, value := range attrs {
+			flattenAttribute(s.Attributes, key, value)
+		}
+		s.setFields()
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(a, b int) bool {
+		return statuses[a].ID < statuses[b].ID
+	})
+	return statuses, nil
+}
+
+// parsePBSObjects parses the objects listed under key in the JSON output of
+// a PBS command.
+func parsePBSObjects(out []byte, key string) ([]*PBSObject, error) {
+	var list map[string]json.RawMessage
+	err := json.Unmarshal(out, &list)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", key, err)
+	}
+	var objects map[string]map[string]interface{}
+	if raw, ok := list[key]; ok {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		err = dec.Decode(&objects)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	var result []*PBSObject
+	for name, attrs := range objects {
+		o := &PBSObject{Name: name, Attributes: map[string]string{}}
+		for key, value := range attrs {
+			flattenAttribute(o.Attributes, key, value)
+		}
+		result = append(result, o)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].Name < result[b].Name
+	})
+	return result, nil
+}
+
+func flattenAttribute(attrs map[string]string, key string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if key == "Variable_List" {
+			var vars []string
+			for name, val := range v {
+				vars = append(vars, name+"="+fmt.Sprint(val))
+			}
+			sort.Strings(vars)
+			attrs[key] = strings.Join(vars, ",")
+			return
+		}
+		for name, val := range v {
+			flattenAttribute(attrs, key+"."+name, val)
+		}
+	case bool:
+		if v {
+			attrs[key] = "True"
+		} else {
+			attrs[key] = "False"
+		}
+	default:
+		attrs[key] = fmt.Sprint(v)
+	}
+}
+
+// setFields sets the fields of the status from its attributes.
+func (s *JobStatus) setFields() {
+	s.Name = s.Attributes["Job_Name"]
+	s.State = s.Attributes["job_state"]
+	s.Substate = s.Attributes["substate"]
+	s.Queue = s.Attributes["queue"]
+	s.Comment = s.Attributes["comment"]
+	s.ExecHost = s.Attributes["exec_host"]
+	s.EstimatedStartTime = s.Attributes["estimated.start_time"]
+}
+
+// parseMemMB converts a PBS size such as "640MB" or "2gb" to megabytes.
+func parseMemMB(size string) (int, error) {
+	lower := strings.ToLower(size)
+	factor := 1.0
+	for _, unit := range []struct {
+		suffix string
+		factor float64
+	}{{"kb", 1.0 / 1024}, {"mb", 1}, {"gb", 1024}, {"tb", 1024 * 1024}, {"b", 1.0 / (1024 * 1024)}} {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			factor = unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(lower, 64)
+	if err != nil {
+		return 0, fmt.Errorf("illegal PBS size %q", size)
+	}
+	return int(n * factor), nil
+}
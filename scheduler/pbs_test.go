@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestParseMemMB(t *testing.T) {
+	tests := map[string]int{"640MB": 640, "2gb": 2048, "1048576kb": 1024}
+	for size, want := range tests {
+		got, err := parseMemMB(size)
+		if err != nil || got != want {
+			t.Errorf("parseMemMB(%q) = %d, %v, want %d", size, got, err, want)
+		}
+	}
+}
+
+func TestParseQstatJSON(t *testing.T) {
+	out := []byte(`{
+		"timestamp": 1700000000,
+		"pbs_version": "19.1.3",
+		"Jobs": {
+			"12.server": {
+				"Job_Name": "web",
+				"job_state": "R",
+				"substate": 42,
+				"queue": "workq",
+				"exec_host": "node1/0*2",
+				"Resource_List": {"ncpus": 2, "mem": "512mb"},
+				"Variable_List": {"PODNAME": "web", "PODNAMESPACE": "default"},
+				"Rerunable": true
+			},
+			"11[].server": {
+				"Job_Name": "sweep",
+				"job_state": "B",
+				"comment": "Job Array Began"
+			}
+		}
+	}`)
+	statuses, err := parseQstatJSON(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || statuses[0].ID != "11[].server" || statuses[1].ID != "12.server" {
+		t.Fatalf("parsed %d statuses, want 11[].server and 12.server sorted", len(statuses))
+	}
+	s := statuses[1]
+	if s.Name != "web" || s.State != "R" || s.Substate != "42" || s.Queue != "workq" {
+		t.Errorf("fields %+v", s)
+	}
+	if s.ExecNode() != "node1" {
+		t.Errorf("exec node %q, want node1", s.ExecNode())
+	}
+	want := map[string]string{
+		"Resource_List.ncpus": "2",
+		"Resource_List.mem":   "512mb",
+		"Variable_List":       "PODNAME=web,PODNAMESPACE=default",
+		"Rerunable":           "True",
+	}
+	for key, value := range want {
+		if s.Attributes[key] != value {
+			t.Errorf("%s = %q, want %q", key, s.Attributes[key], value)
+		}
+	}
+	if statuses[0].Comment != "Job Array Began" {
+		t.Errorf("comment %q", statuses[0].Comment)
+	}
+
+	_, err = parseQstatJSON([]byte("qstat: Unknown Job Id"))
+	if err == nil {
+		t.Error("invalid output parsed")
+	}
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// The annotations setting the PBS settings of a pod the namespace policy
+// leaves unset or lets pods override.
+const (
+	queueAnnotation    = "PBSQueue"
+	walltimeAnnotation = "PBSWalltime"
+	projectAnnotation  = "PBSProject"
+)
+
+// defaultPolicyKey is the key of the namespace policy ConfigMap holding the
+// policy of namespaces without their own entry. Namespace names cannot
+// start with a dot, so it never collides with one.
+const defaultPolicyKey = ".defaults"
+
+// PolicyConfig locates the ConfigMap holding the per namespace policies.
+type PolicyConfig struct {
+	Namespace string `json:"namespace"`
+	ConfigMap string `json:"config_map"`
+}
+
+// NamespacePolicy gives the PBS settings and limits of the pods of a
+// namespace. Each ConfigMap entry is the JSON policy of the namespace named
+// by its key.
+type NamespacePolicy struct {
+	Queue    string `json:"queue,omitempty"`
+	Project  string `json:"project,omitempty"`
+	Account  string `json:"account,omitempty"`
+	Walltime string `json:"walltime,omitempty"`
+	MaxNcpus int    `json:"max_ncpus,omitempty"`
+	MaxMem   string `json:"max_mem,omitempty"`
+	// Overrides lists the settings, among queue, walltime and project,
+	// that pod annotations may change when the policy sets them.
+	Overrides []string `json:"overrides,omitempty"`
+}
+
+// walltimeFormat matches the PBS walltime formats: seconds, or
+// [[hours:]minutes:]seconds.
+var walltimeFormat = regexp.MustCompile(`^([0-9]+:){0,2}[0-9]+$`)
+
+func (p *NamespacePolicy) validate() error {
+	if p.Walltime != "" && !walltimeFormat.MatchString(p.Walltime) {
+		return fmt.Errorf("invalid walltime %q", p.Walltime)
+	}
+	if p.MaxMem != "" {
+		_, err := parseMemMB(p.MaxMem)
+		if err != nil {
+			return err
+		}
+	}
+	if p.MaxNcpus < 0 {
+		return fmt.Errorf("invalid max_ncpus %d", p.MaxNcpus)
+	}
+	for _, setting := range p.Overrides {
+		switch setting {
+		case "queue", "walltime", "project":
+		default:
+			return fmt.Errorf("unknown override %q", setting)
+		}
+	}
+	return nil
+}
+
+// merge overrides the settings of p with the ones set in o.
+func (p NamespacePolicy) merge(o NamespacePolicy) NamespacePolicy {
+	if o.Queue != "" {
+		p.Queue = o.Queue
+	}
+	if o.Project != "" {
+		p.Project = o.Project
+	}
+	if o.Account != "" {
+		p.Account = o.Account
+	}
+	if o.Walltime != "" {
+		p.Walltime = o.Walltime
+	}
+	if o.MaxNcpus != 0 {
+		p.MaxNcpus = o.MaxNcpus
+	}
+	if o.MaxMem != "" {
+		p.MaxMem = o.MaxMem
+	}
+	if o.Overrides != nil {
+		p.Overrides = o.Overrides
+	}
+	return p
+}
+
+// annotate applies the settings of the pod annotations or job template the
+// policy leaves unset or lets pods override.
+func (p NamespacePolicy) annotate(o NamespacePolicy) NamespacePolicy {
+	if o.Queue != "" && (p.Queue == "" || p.overrides("queue")) {
+		p.Queue = o.Queue
+	}
+	if o.Walltime != "" && (p.Walltime == "" || p.overrides("walltime")) {
+		p.Walltime = o.Walltime
+	}
+	if o.Project != "" && (p.Project == "" || p.overrides("project")) {
+		p.Project = o.Project
+	}
+	return p
+}
+
+// overrides reports whether pod annotations and job templates may change
+// the setting.
+func (p NamespacePolicy) overrides(setting string) bool {
+	for _, s := range p.Overrides {
+		if s == setting {
+			return true
+		}
+	}
+	return false
+}
+
+// namespacePolicy returns the policy of the namespace: its entry of the
+// policy ConfigMap on top of the defaults entry. Without a configured or
+// existing ConfigMap, the policy is empty.
+func namespacePolicy(namespace string) (*NamespacePolicy, error) {
+	policy := &NamespacePolicy{}
+	c := config.Policy
+	if c.ConfigMap == "" {
+		return policy, nil
+	}
+	ns := c.Namespace
+	if ns == "" {
+		ns = "default"
+	}
+	cm, err := getConfigMap(ns, c.ConfigMap)
+	if err != nil {
+		return nil, err
+	}
+	if cm == nil {
+		return policy, nil
+	}
+	for _, key := range []string{defaultPolicyKey, namespace} {
+		data, ok := cm.Data[key]
+		if !ok {
+			continue
+		}
+		var entry NamespacePolicy
+		err = json.Unmarshal([]byte(data), &entry)
+		if err == nil {
+			err = entry.validate()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Policy: %s/%s[%s]: %v", ns, c.ConfigMap, key, err)
+		}
+		*policy = policy.merge(entry)
+	}
+	return policy, nil
+}
+
+// podPolicy returns the settings of the PBSQueue, PBSWalltime and
+// PBSProject annotations of the pod.
+func podPolicy(pod *Pod) (NamespacePolicy, error) {
+	p := NamespacePolicy{
+		Queue:    pod.Metadata.Annotations[queueAnnotation],
+		Walltime: pod.Metadata.Annotations[walltimeAnnotation],
+		Project:  pod.Metadata.Annotations[projectAnnotation],
+	}
+	if p.Walltime != "" && !walltimeFormat.MatchString(p.Walltime) {
+		return p, fmt.Errorf("invalid %s annotation %q", walltimeAnnotation, p.Walltime)
+	}
+	return p, nil
+}
+
+// check rejects requests above the limits of the policy.
+func (p *NamespacePolicy) check(ncpus int, memMB int) error {
+	if p.MaxNcpus > 0 && ncpus > p.MaxNcpus {
+		return fmt.Errorf("requests %d cpus, above the namespace limit of %d", ncpus, p.MaxNcpus)
+	}
+	if p.MaxMem != "" {
+		max, _ := parseMemMB(p.MaxMem)
+		if memMB > max {
+			return fmt.Errorf("requests %dMB of memory, above the namespace limit of %s", memMB, p.MaxMem)
+		}
+	}
+	return nil
+}
+
+// args returns the qsub options applying the policy. The queue of the
+// policy is not used when a priority mapping already chose one.
+func (p *NamespacePolicy) args(priorityQueue bool) []string {
+	var args []string
+	if p.Queue != "" && !priorityQueue {
+		args = append(args, "-q", p.Queue)
+	}
+	if p.Project != "" {
+		args = append(args, "-P", p.Project)
+	}
+	if p.Account != "" {
+		args = append(args, "-A", p.Account)
+	}
+	if p.Walltime != "" {
+		args = append(args, "-l", "walltime="+p.Walltime)
+	}
+	return args
+}
+
+// hasOption reports whether the command line arguments contain the option.
+func hasOption(args []string, option string) bool {
+	for _, arg := range args {
+		if arg == option {
+			return true
+		}
+	}
+	return false
+}
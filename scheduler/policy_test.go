@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestNamespacePolicyAnnotate(t *testing.T) {
+	annotated := NamespacePolicy{Queue: "express", Walltime: "02:00:00", Project: "p1"}
+	tests := []struct {
+		name   string
+		policy NamespacePolicy
+		want   NamespacePolicy
+	}{
+		{"unset", NamespacePolicy{}, annotated},
+		{"authoritative", NamespacePolicy{Queue: "workq", Walltime: "01:00:00"}, NamespacePolicy{Queue: "workq", Walltime: "01:00:00", Project: "p1"}},
+		{"overridable walltime",
+			NamespacePolicy{Queue: "workq", Walltime: "01:00:00", Overrides: []string{"walltime"}},
+			NamespacePolicy{Queue: "workq", Walltime: "02:00:00", Project: "p1", Overrides: []string{"walltime"}}},
+	}
+	for _, tt := range tests {
+		got := tt.policy.annotate(annotated)
+		if got.Queue != tt.want.Queue || got.Walltime != tt.want.Walltime || got.Project != tt.want.Project {
+			t.Errorf("%s: annotate = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNamespacePolicyValidateOverrides(t *testing.T) {
+	p := NamespacePolicy{Overrides: []string{"walltime", "project"}}
+	if err := p.validate(); err != nil {
+		t.Error(err)
+	}
+	p = NamespacePolicy{Overrides: []string{"max_ncpus"}}
+	if err := p.validate(); err == nil {
+		t.Error("override of max_ncpus accepted")
+	}
+}
+
+func TestJobTemplateCannotOverridePolicy(t *testing.T) {
+	c := newTestCluster(t)
+	config.Policy = PolicyConfig{Namespace: "kube-system", ConfigMap: "pbs-policy"}
+	c.api.AddConfigMap("kube-system", ConfigMap{
+		Metadata: Metadata{Name: "pbs-policy"},
+		Data:     map[string]string{"team": `{"queue": "teamq", "overrides": ["walltime"]}`},
+	})
+	c.api.AddJobTemplate("team", PBSJobTemplate{
+		Metadata: Metadata{Name: "bypass", Namespace: "team"},
+		Spec:     PBSJobTemplateSpec{Queue: "express", Walltime: "48:00:00"},
+	})
+	pod := testPod("web", "1", "100Mi")
+	pod.Metadata.Namespace = "team"
+	pod.Metadata.Annotations = map[string]string{jobTemplateAnnotation: "bypass"}
+
+	req, err := podJobRequest(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.policy.Queue != "teamq" || req.policy.Walltime != "48:00:00" {
+		t.Errorf("policy %+v, want the namespace queue and the template walltime", req.policy)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+var errEvictionBlocked = errors.New("Eviction: blocked by a PodDisruptionBudget")
+
+// preemptedReason explains why a bound pod must give up its node given the
+// status of its PBS job, or returns "" if the job still holds the node.
+func preemptedReason(status *JobStatus) string {
+	if status == nil {
+		return "was deleted"
+	}
+	switch status.State {
+	case "R", "E":
+		return ""
+	case "S", "U":
+		return "was suspended"
+	case "Q", "H", "W":
+		return "was requeued"
+	}
+	return ""
+}
+
+// syncBoundPods mirrors the PBS job status onto the bound pods, ends the
+// jobs of terminated pods and evicts running pods whose PBS job was
+// preempted (suspended, checkpointed, requeued or deleted) so that they
+// release the resources PBS now considers free. It also records the
+// deletion of the pods with a job in the state store.
+func syncBoundPods() error {
+	pods, err := getPods("spec.schedulerName=" + schedulerName())
+	if err != nil {
+		return err
+	}
+	recordDeletedPods(pods.Items)
+	auditLog.prune(pods.Items)
+	if fs, ok := stateStore.(*fileStore); ok {
+		err = fs.compact()
+		if err != nil {
+			log.Printf("State store: %v", err)
+		}
+	}
+	for _, pod := range pods.Items {
+		jobid := pod.Metadata.Annotations["JobID"]
+		if pod.Spec.NodeName == "" || jobid == "" || pod.Metadata.DeletionTimestamp != "" {
+			continue
+		}
+		terminated := pod.Status.Phase == "Succeeded" || pod.Status.Phase == "Failed"
+		state := pod.Metadata.Annotations[jobStateAnnotation]
+		if terminated && (state == "F" || state == "X") {
+			continue
+		}
+
+		status, err := pbsServer.Status(jobid)
+		if err != nil && err != errUnknownJob {
+			log.Println(err)
+			continue
+		}
+		if status != nil {
+			err = reflectJobStatus(&pod, status)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+		if terminated {
+			err = finishJob(&pod, status)
+			if err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		reason := preemptedReason(status)
+		if reason == "" {
+			continue
+		}
+
+		err = evict(&pod)
+		if err == errEvictionBlocked {
+			log.Printf("PBS job %s %s, eviction of pod %s postponed by its disruption budget", jobid, reason, pod.Metadata.Name)
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		msg := fmt.Sprintf("PBS job %s %s, evicted pod %s", jobid, reason, pod.Metadata.Name)
+		log.Println(msg)
+		recorder.Record(&pod, "Preempted", "Warning", msg)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTrackBoundPodsEvictsPreempted(t *testing.T) {
+	c := newTestCluster(t, "node1")
+	c.api.AddPod("default", testPod("web", "1", "100Mi"))
+	pod := c.pod(t, "web")
+	err := schedulePod(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The job starts running (41) in one cycle and executes (42) in the next.
+	c.pbs.Schedule()
+	c.pbs.Schedule()
+	pod = c.pod(t, "web")
+	err = schedulePod(&pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobid := c.pod(t, "web").Metadata.Annotations["JobID"]
+	err = c.pbs.Suspend(jobid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go trackBoundPods(0, done, &wg)
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+	if !eventually(t, 5*time.Second, func() bool {
+		_, ok := c.api.Pod("default", "web")
+		return !ok
+	}) {
+		t.Error("pod of the suspended job not evicted")
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"strconv"
+)
+
+// PriorityMapping translates a pod priority into PBS submission options.
+// A mapping matches a pod either by its priorityClassName or, when no class
+// name is given, by a spec.priority of at least MinPriority.
+type PriorityMapping struct {
+	PriorityClassName string `json:"priority_class_name,omitempty"`
+	MinPriority       *int32 `json:"min_priority,omitempty"`
+	PBSPriority       *int   `json:"pbs_priority,omitempty"`
+	Queue             string `json:"queue,omitempty"`
+}
+
+// matchPriority returns the first mapping matching the pod, or nil.
+func matchPriority(pod *Pod, mappings []PriorityMapping) *PriorityMapping {
+	for i, m := range mappings {
+		if m.PriorityClassName != "" {
+			if m.PriorityClassName == pod.Spec.PriorityClassName {
+				return &mappings[i]
+			}
+			continue
+		}
+		if pod.Spec.Priority != nil && *pod.Spec.Priority >= *m.MinPriority {
+			return &mappings[i]
+		}
+	}
+	return nil
+}
+
+// priorityArgs returns the qsub options selecting the PBS priority and
+// destination queue of the pod's job.
+func priorityArgs(pod *Pod) []string {
+	m := matchPriority(pod, config.PriorityClasses)
+	if m == nil {
+		return nil
+	}
+	var args []string
+	if m.PBSPriority != nil {
+		args = append(args, "-p", strconv.Itoa(*m.PBSPriority))
+	}
+	if m.Queue != "" {
+		args = append(args, "-q", m.Queue)
+	}
+	return args
+}
@@ -55,6 +55,10 @@ func resolveUnscheduledPods(interval int, done chan struct{}, wg *sync.WaitGroup
 			if err != nil {
 				log.Println(err)
 			}
+			recorder.Flush()
+			if dryRun {
+				dryRunStats.flush()
+			}
 			log.Println("End of Iteration")
 		case <-done:
 			wg.Done()
@@ -64,6 +68,24 @@ func resolveUnscheduledPods(interval int, done chan struct{}, wg *sync.WaitGroup
 	}
 }
 
+func trackBoundPods(interval int, done chan struct{}, wg *sync.WaitGroup) {
+	for {
+		select {
+		case <-time.After(time.Duration(interval) * time.Second):
+			processLock.Lock()
+			err := syncBoundPods()
+			processLock.Unlock()
+			if err != nil {
+				log.Println(err)
+			}
+		case <-done:
+			wg.Done()
+			log.Println("Stopped bound pod tracking.")
+			return
+		}
+	}
+}
+
 func trackUnscheduledPods(done chan struct{}, wg *sync.WaitGroup) {	
 	pods, errc := watchUnscheduledPods()
 
@@ -88,14 +110,21 @@ func trackUnscheduledPods(done chan struct{}, wg *sync.WaitGroup) {
 }
 
 func schedulePod(pod *Pod) error {	
+	if pod.Metadata.Annotations[managedAnnotation] == "false" {
+		return nil
+	}
+	beginDecision(pod)
 	nodevalue,err := fit(pod)
 	if err != nil {
+		endDecision(pod, "", err)
 		return err
 	}
 	if nodevalue == "" {
+		endDecision(pod, "", nil)
 		return nil
 	}
 	err = bind(pod, nodevalue)
+	endDecision(pod, nodevalue, err)
 	if err != nil {
 		return err
 	}	
@@ -103,17 +132,32 @@ func schedulePod(pod *Pod) error {
 }
 
 func reschedulePod() error {
+	failed, err := scheduleUnscheduledPods()
+	for _, err := range failed {
+		log.Println(err)
+	}
+	return err
+}
+
+// scheduleUnscheduledPods schedules every pending pod and returns the errors
+// of the pods that failed, by pod name.
+func scheduleUnscheduledPods() (map[string]error, error) {
 	processLock.Lock()
 	defer processLock.Unlock()
+	if s, ok := stateStore.(*configMapStore); ok {
+		s.beginPass()
+		defer s.endPass()
+	}
 	pods, err := getUnscheduledPods()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, pod := range pods.Items {		
+	failed := map[string]error{}
+	for _, pod := range pods.Items {
 		err := schedulePod(&pod)
 		if err != nil {
-			log.Println(err)
+			failed[pod.Metadata.Name] = err
 		}
 	}
-	return nil
+	return failed, nil
 }
@@ -0,0 +1,255 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+)
+
+// jobRequest is the translation of a pod into the PBS job holding its
+// resources.
+type jobRequest struct {
+	ncpus       int
+	memMB       int
+	constraints string
+	policy      *NamespacePolicy
+	template    *PBSJobTemplateSpec
+	workload    *Workload
+	priority    []string
+	user        string
+	group       string
+	// inputs are the cluster objects the request was computed from, reused
+	// for the other pods of an array.
+	inputs *jobInputs
+}
+
+// jobInputs are the objects of the cluster shared by the job requests of
+// the pods of a namespace.
+type jobInputs struct {
+	nodes     []Node
+	policy    *NamespacePolicy
+	templates map[string]*PBSJobTemplateSpec
+}
+
+// rejection is the reason a pod cannot be submitted to PBS as it is.
+type rejection struct {
+	kind string
+	msg  string
+}
+
+func (r *rejection) Error() string {
+	return r.kind + ": " + r.msg
+}
+
+// quantityFormat matches Kubernetes resource quantities: a decimal number
+// with a binary (Ki, Mi...), decimal (m, k, M...) or exponent suffix.
+var quantityFormat = regexp.MustCompile(`^([0-9]+(?:\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+|[mkMGTPE]|[KMGTPE]i)?$`)
+
+// quantitySuffixes are the factors of the suffixes of the quantities.
+var quantitySuffixes = map[string]float64{
+	"":   1,
+	"m":  1e-3,
+	"k":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"P":  1e15,
+	"E":  1e18,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+	"Pi": 1 << 50,
+	"Ei": 1 << 60,
+}
+
+// parseQuantity returns the value of a Kubernetes resource quantity.
+func parseQuantity(q string) (float64, error) {
+	m := quantityFormat.FindStringSubmatch(q)
+	if m == nil {
+		return 0, fmt.Errorf("%q is not a resource quantity", q)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a resource quantity", q)
+	}
+	factor, ok := quantitySuffixes[m[2]]
+	if !ok {
+		// An exponent, such as e6.
+		return strconv.ParseFloat(m[1]+m[2], 64)
+	}
+	return n * factor, nil
+}
+
+// podResources returns the ncpus and the memory in MB requested by the
+// containers of the pod. Fractions of cpus and MB are rounded up, since
+// PBS only allocates whole ones.
+func podResources(pod *Pod) (int, int, error) {
+	var cpus float64
+	var memBytes float64
+
+	for _, c := range pod.Spec.Containers {
+		n, err := parseQuantity(c.Resources.Requests["cpu"])
+		if err != nil {
+			return 0, 0, fmt.Errorf("container %s: cpu request: %v", c.Name, err)
+		}
+		cpus += n
+	}
+
+	for _, c := range pod.Spec.Containers {
+		memory := c.Resources.Requests["memory"]
+		if memory == "" {
+			continue
+		}
+		n, err := parseQuantity(memory)
+		if err != nil {
+			return 0, 0, fmt.Errorf("container %s: memory request: %v", c.Name, err)
+		}
+		memBytes += n
+	}
+	// Kubernetes resolves cpus to the millicpu.
+	ncpus := int(math.Ceil(math.Round(cpus*1000) / 1000))
+	memMB := int(math.Ceil(math.Round(memBytes) / (1 << 20)))
+	return ncpus, memMB, nil
+}
+
+// podJobRequest translates the pod into a PBS job request. Pods that
+// cannot be submitted get a *rejection.
+func podJobRequest(pod *Pod) (*jobRequest, error) {
+	nodes, err := getNodes()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := namespacePolicy(namespaceOf(pod))
+	if err != nil {
+		return nil, err
+	}
+	in := &jobInputs{nodes: nodes.Items, policy: policy, templates: map[string]*PBSJobTemplateSpec{}}
+	return in.request(pod)
+}
+
+// template returns the job template of the pod, looking each template up
+// once.
+func (in *jobInputs) template(pod *Pod) (*PBSJobTemplateSpec, error) {
+	name := pod.Metadata.Annotations[jobTemplateAnnotation]
+	if t, ok := in.templates[name]; ok {
+		return t, nil
+	}
+	t, err := podJobTemplate(pod)
+	if err != nil {
+		return nil, err
+	}
+	in.templates[name] = t
+	return t, nil
+}
+
+// request translates a pod of the namespace of the inputs into a PBS job
+// request, like podJobRequest.
+func (in *jobInputs) request(pod *Pod) (*jobRequest, error) {
+	var err error
+	req := &jobRequest{inputs: in}
+
+	req.ncpus, req.memMB, err = podResources(pod)
+	if err != nil {
+		msg := fmt.Sprintf("pod (%s) cannot be submitted to PBS: %v", pod.Metadata.Name, err)
+		return nil, &rejection{"Resources", msg}
+	}
+
+	req.constraints, err = placement(pod, in.nodes)
+	if err != nil {
+		msg := fmt.Sprintf("pod (%s) cannot be scheduled by PBS: %v", pod.Metadata.Name, err)
+		return nil, &rejection{"Placement", msg}
+	}
+
+	policy := *in.policy
+	req.policy = &policy
+	err = req.policy.check(req.ncpus, req.memMB)
+	if err != nil {
+		msg := fmt.Sprintf("pod (%s) %v", pod.Metadata.Name, err)
+		return nil, &rejection{"Policy", msg}
+	}
+
+	req.template, err = in.template(pod)
+	if err != nil {
+		msg := fmt.Sprintf("pod (%s) cannot be submitted to PBS: %v", pod.Metadata.Name, err)
+		return nil, &rejection{"Template", msg}
+	}
+	req.workload = podWorkload(pod)
+	*req.policy = workloadPolicy(req.workload).merge(*req.policy)
+	*req.policy = req.policy.annotate(NamespacePolicy{Queue: req.template.Queue, Walltime: req.template.Walltime})
+	annotated, err := podPolicy(pod)
+	if err != nil {
+		msg := fmt.Sprintf("pod (%s) cannot be submitted to PBS: %v", pod.Metadata.Name, err)
+		return nil, &rejection{"Annotations", msg}
+	}
+	*req.policy = req.policy.annotate(annotated)
+
+	req.user, req.group, err = jobOwner(pod)
+	if err != nil {
+		msg := fmt.Sprintf("pod (%s) cannot be submitted to PBS: %v", pod.Metadata.Name, err)
+		return nil, &rejection{"Identity", msg}
+	}
+
+	req.priority = priorityArgs(pod)
+	return req, nil
+}
+
+// queue returns the queue the job is submitted to, or "" for the default
+// queue of the PBS server.
+func (r *jobRequest) queue() string {
+	for i, arg := range r.priority {
+		if arg == "-q" && i+1 < len(r.priority) {
+			return r.priority[i+1]
+		}
+	}
+	return r.policy.Queue
+}
+
+// same reports whether the two requests translate into the same PBS job
+// but for the pod, so that their pods can be subjobs of one array.
+func (r *jobRequest) same(o *jobRequest) bool {
+	return r.ncpus == o.ncpus && r.memMB == o.memMB && r.constraints == o.constraints &&
+		reflect.DeepEqual(r.policy, o.policy) && reflect.DeepEqual(r.template, o.template) &&
+		workloadVariables(r.workload) == workloadVariables(o.workload) &&
+		reflect.DeepEqual(r.priority, o.priority) && r.user == o.user && r.group == o.group
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestPodResources(t *testing.T) {
+	tests := []struct {
+		cpu    string
+		memory string
+		ncpus  int
+		memMB  int
+	}{
+		{"1", "640Mi", 1, 640},
+		{"500m", "1Gi", 1, 1024},
+		{"1500m", "1G", 2, 954},
+		{"0.5", "512Ki", 1, 1},
+		{"2", "1.5Gi", 2, 1536},
+		{"4", "128974848", 4, 123},
+		{"1", "129e6", 1, 124},
+		{"1", "", 1, 0},
+		{"1", "1Ti", 1, 1024 * 1024},
+	}
+	for _, tt := range tests {
+		pod := testPod("web", tt.cpu, tt.memory)
+		ncpus, memMB, err := podResources(&pod)
+		if err != nil || ncpus != tt.ncpus || memMB != tt.memMB {
+			t.Errorf("podResources(cpu %q, memory %q) = %d, %d, %v, want %d, %d", tt.cpu, tt.memory, ncpus, memMB, err, tt.ncpus, tt.memMB)
+		}
+	}
+
+	for _, bad := range []string{"", "1GB", "one", "-1", "1.5.2", "Inf", "0x10"} {
+		pod := testPod("web", bad, "100Mi")
+		if _, _, err := podResources(&pod); err == nil {
+			t.Errorf("cpu request %q accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+//go:build simulate
+
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// Trace is a recorded workload replayed by the simulate command.
+type Trace struct {
+	Nodes  []TraceNode  `json:"nodes"`
+	Queues []string     `json:"queues,omitempty"`
+	Events []TraceEvent `json:"events"`
+}
+
+// TraceNode is a node present in both Kubernetes and PBS.
+type TraceNode struct {
+	Name      string            `json:"name"`
+	Ncpus     int               `json:"ncpus"`
+	MemMB     int               `json:"mem_mb"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Taints    []Taint           `json:"taints,omitempty"`
+	Resources map[string]string `json:"resources,omitempty"`
+}
+
+// TraceEvent creates a pod, which runs for Runtime seconds once bound, or
+// deletes one, Time seconds after the start of the trace. Created pods
+// without a schedulerName select the simulated scheduler.
+type TraceEvent struct {
+	Time    int    `json:"time"`
+	Create  *Pod   `json:"create,omitempty"`
+	Runtime int    `json:"runtime,omitempty"`
+	Delete  string `json:"delete,omitempty"`
+}
+
+// SimulationReport summarizes a simulation.
+type SimulationReport struct {
+	Pods    int `json:"pods"`
+	Bound   int `json:"bound"`
+	Unbound int `json:"unbound"`
+	Evicted int `json:"evicted"`
+	Errors  int `json:"errors"`
+	// FailedPods holds the last scheduling error of the pods that had one.
+	FailedPods     map[string]string `json:"failed_pods,omitempty"`
+	MeanTimeToBind float64           `json:"mean_time_to_bind"`
+	P50TimeToBind  int               `json:"p50_time_to_bind"`
+	P95TimeToBind  int               `json:"p95_time_to_bind"`
+	MaxTimeToBind  int               `json:"max_time_to_bind"`
+	Utilization    float64           `json:"mean_cpu_utilization"`
+	Duration       int               `json:"duration"`
+}
+
+type simulatedPod struct {
+	created int
+	runtime int
+	bound   int
+	jobid   string
+	done    bool
+	evicted bool
+}
+
+func init() {
+	commands["simulate"] = runSimulate
+}
+
+// runSimulate implements the simulate command: it replays a trace through
+// the real scheduling logic against the fake API server and PBS, in
+// simulated time advancing by one scheduling interval per step.
+func runSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	tracePath := fs.String("trace", "", "path to the JSON workload trace")
+	interval := fs.Int("interval", 20, "simulated seconds between scheduling iterations")
+	maxTime := fs.Int("max-time", 86400, "simulated seconds after which the simulation stops")
+	output := fs.String("o", "text", "report format: text or json")
+	verbose := fs.Bool("v", false, "show the scheduler log")
+	fs.Parse(args)
+
+	if *tracePath == "" {
+		return fmt.Errorf("simulate: -trace is required")
+	}
+	b, err := ioutil.ReadFile(*tracePath)
+	if err != nil {
+		return err
+	}
+	var trace Trace
+	err = json.Unmarshal(b, &trace)
+	if err != nil {
+		return fmt.Errorf("simulate: %s: %v", *tracePath, err)
+	}
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	report, err := simulate(&trace, *interval, *maxTime)
+	if err != nil {
+		return err
+	}
+	return writeReport(os.Stdout, report, *output)
+}
+
+func simulate(trace *Trace, interval int, maxTime int) (*SimulationReport, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("simulate: interval must be positive")
+	}
+	server := NewFakeAPIServer()
+	defer server.Close()
+	pbs := NewFakePBS()
+	for _, q := range trace.Queues {
+		pbs.AddQueue(q)
+	}
+	for _, n := range trace.Nodes {
+		labels := map[string]string{hostnameLabel: n.Name}
+		for k, v := range n.Labels {
+			labels[k] = v
+		}
+		server.AddNode(Node{
+			Metadata: Metadata{Name: n.Name, Labels: labels},
+			Spec:     NodeSpec{Taints: n.Taints},
+		})
+		pbs.AddNode(&FakePBSNode{Name: n.Name, Ncpus: n.Ncpus, MemMB: n.MemMB, Resources: n.Resources})
+	}
+
+	savedHost, savedPBS, savedDelay, savedRecorder := apiHost, pbsServer, submitDelay, recorder
+	defer func() {
+		apiHost, pbsServer, submitDelay, recorder = savedHost, savedPBS, savedDelay, savedRecorder
+	}()
+	apiHost = server.Host()
+	pbsServer = pbs
+	submitDelay = 0
+	recorder = newEventRecorder(coreEventSink{}, time.Minute)
+
+	events := append([]TraceEvent(nil), trace.Events...)
+	sort.SliceStable(events, func(a, b int) bool { return events[a].Time < events[b].Time })
+
+	pods := map[string]*simulatedPod{}
+	report := &SimulationReport{}
+	var utilization float64
+	steps := 0
+	now := 0
+	for {
+		for len(events) > 0 && events[0].Time <= now {
+			e := events[0]
+			events = events[1:]
+			if e.Create != nil {
+				if e.Create.Spec.SchedulerName == "" {
+					e.Create.Spec.SchedulerName = schedulerName()
+				}
+				server.AddPod("default", *e.Create)
+				pods[e.Create.Metadata.Name] = &simulatedPod{created: now, runtime: e.Runtime, bound: -1}
+			}
+			if e.Delete != "" {
+				server.DeletePod("default", e.Delete)
+				if sp, ok := pods[e.Delete]; ok {
+					sp.done = true
+					if sp.jobid != "" {
+						pbs.Delete(sp.jobid)
+					}
+				}
+			}
+		}
+
+		// Pods that ran for their runtime complete, ending their job.
+		for name, sp := range pods {
+			if sp.done || sp.bound < 0 || sp.runtime <= 0 || now-sp.bound < sp.runtime {
+				continue
+			}
+			sp.done = true
+			pbs.Finish(sp.jobid, 0)
+			server.DeletePod("default", name)
+		}
+
+		pbs.Schedule()
+		failed, err := scheduleUnscheduledPods()
+		if err != nil {
+			report.Errors++
+		}
+		for name, err := range failed {
+			report.Errors++
+			if report.FailedPods == nil {
+				report.FailedPods = map[string]string{}
+			}
+			report.FailedPods[name] = err.Error()
+		}
+		if err := syncBoundPods(); err != nil {
+			report.Errors++
+		}
+
+		for name, sp := range pods {
+			if sp.done {
+				continue
+			}
+			pod, ok := server.Pod("default", name)
+			if !ok {
+				// Only the scheduler removes pods at this point: by eviction.
+				sp.done = true
+				sp.evicted = true
+				continue
+			}
+			sp.jobid = pod.Metadata.Annotations["JobID"]
+			if sp.bound < 0 && pod.Spec.NodeName != "" {
+				sp.bound = now
+			}
+		}
+
+		used, total := pbs.Utilization()
+		if total > 0 {
+			utilization += float64(used) / float64(total)
+		}
+		steps++
+
+		if len(events) == 0 && allSettled(pods, pbs) {
+			break
+		}
+		if now >= maxTime {
+			break
+		}
+		now += interval
+	}
+
+	var ttb []int
+	for _, sp := range pods {
+		report.Pods++
+		switch {
+		case sp.evicted:
+			report.Evicted++
+		case sp.bound >= 0:
+			report.Bound++
+			ttb = append(ttb, sp.bound-sp.created)
+		default:
+			report.Unbound++
+		}
+	}
+	sort.Ints(ttb)
+	if len(ttb) > 0 {
+		sum := 0
+		for _, t := range ttb {
+			sum += t
+		}
+		report.MeanTimeToBind = float64(sum) / float64(len(ttb))
+		report.P50TimeToBind = ttb[len(ttb)*50/100]
+		report.P95TimeToBind = ttb[len(ttb)*95/100]
+		report.MaxTimeToBind = ttb[len(ttb)-1]
+	}
+	report.Utilization = utilization / float64(steps)
+	report.Duration = now
+	return report, nil
+}
+
+// allSettled reports whether no pod can change state anymore: no pod with
+// a runtime is running, so no resources will be freed, and every pending
+// pod has a job that PBS already declined to run.
+func allSettled(pods map[string]*simulatedPod, pbs *FakePBS) bool {
+	for _, sp := range pods {
+		if sp.done {
+			continue
+		}
+		if sp.bound >= 0 {
+			if sp.runtime > 0 {
+				return false
+			}
+			continue
+		}
+		if sp.jobid == "" {
+			return false
+		}
+		status, err := pbs.Status(sp.jobid)
+		if err != nil {
+			continue
+		}
+		if status.State == "R" || (status.State == "Q" && status.Comment == "") {
+			return false
+		}
+	}
+	return true
+}
+
+func writeReport(w io.Writer, report *SimulationReport, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	if format != "text" {
+		return fmt.Errorf("simulate: unknown report format %q", format)
+	}
+	fmt.Fprintf(w, "Simulated time:        %ds\n", report.Duration)
+	fmt.Fprintf(w, "Pods:                  %d\n", report.Pods)
+	fmt.Fprintf(w, "Bound:                 %d\n", report.Bound)
+	fmt.Fprintf(w, "Never bound:           %d\n", report.Unbound)
+	fmt.Fprintf(w, "Evicted:               %d\n", report.Evicted)
+	fmt.Fprintf(w, "Scheduling errors:     %d\n", report.Errors)
+	fmt.Fprintf(w, "Time to bind (mean):   %.1fs\n", report.MeanTimeToBind)
+	fmt.Fprintf(w, "Time to bind (p50):    %ds\n", report.P50TimeToBind)
+	fmt.Fprintf(w, "Time to bind (p95):    %ds\n", report.P95TimeToBind)
+	fmt.Fprintf(w, "Time to bind (max):    %ds\n", report.MaxTimeToBind)
+	fmt.Fprintf(w, "CPU utilization:       %.1f%%\n", report.Utilization*100)
+	if len(report.FailedPods) > 0 {
+		names := make([]string, 0, len(report.FailedPods))
+		for name := range report.FailedPods {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(w, "Failed pods:")
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s: %s\n", name, report.FailedPods[name])
+		}
+	}
+	return nil
+}
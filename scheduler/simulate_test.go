@@ -0,0 +1,73 @@
+//go:build simulate
+
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestSimulate(t *testing.T) {
+	newTestCluster(t)
+	big := testPod("big", "8", "100Mi")
+	big.Spec.SchedulerName = ""
+	trace := &Trace{
+		Nodes: []TraceNode{{Name: "node1", Ncpus: 2, MemMB: 1024}},
+		Events: []TraceEvent{
+			{Time: 0, Create: podPtr(testPod("first", "2", "100Mi")), Runtime: 30},
+			{Time: 0, Create: podPtr(testPod("second", "2", "100Mi")), Runtime: 30},
+			{Time: 10, Create: &big},
+		},
+	}
+	report, err := simulate(trace, 20, 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Pods != 3 || report.Bound != 2 || report.Unbound != 1 {
+		t.Errorf("report %+v, want 2 of 3 pods bound", report)
+	}
+	if report.MaxTimeToBind < 40 {
+		t.Errorf("max time to bind %ds, want the second pod to wait for the first", report.MaxTimeToBind)
+	}
+}
+
+func podPtr(pod Pod) *Pod {
+	return &pod
+}
@@ -0,0 +1,638 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Events of the job records.
+const (
+	jobSubmitted = "submitted"
+	jobState     = "state"
+	jobBound     = "bound"
+	jobCleared   = "cleared"
+	jobFinished  = "finished"
+	podDeleted   = "deleted"
+)
+
+// JobRecord is a transition of the PBS job of a pod kept in the state store.
+type JobRecord struct {
+	Time      string `json:"time"`
+	UID       string `json:"uid"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	JobID     string `json:"jobId"`
+	Event     string `json:"event"`
+	State     string `json:"state,omitempty"`
+	Node      string `json:"node,omitempty"`
+}
+
+// StateStore keeps the history of the PBS jobs of the pods, so that the job
+// of a pod whose JobID annotation was lost is recovered after a restart.
+type StateStore interface {
+	// Record appends a transition to the history of a pod.
+	Record(r JobRecord) error
+	// Records returns the history of the pod with the given UID, or of all
+	// the pods for "", oldest first.
+	Records(uid string) ([]JobRecord, error)
+}
+
+// StateStoreConfig selects the state store.
+type StateStoreConfig struct {
+	// Type is "annotations" (the default), "configmap" or "file".
+	Type string `json:"type"`
+	// ConfigMap is the "namespace/name" of the ConfigMap of the configmap
+	// store.
+	ConfigMap string `json:"config_map"`
+	// Path is the file of the file store.
+	Path string `json:"path"`
+}
+
+func (c *StateStoreConfig) validate() error {
+	switch c.Type {
+	case "", "annotations":
+	case "configmap":
+		if strings.Count(c.ConfigMap, "/") != 1 || strings.HasPrefix(c.ConfigMap, "/") || strings.HasSuffix(c.ConfigMap, "/") {
+			return fmt.Errorf("config_map %q is not namespace/name", c.ConfigMap)
+		}
+	case "file":
+		if c.Path == "" {
+			return fmt.Errorf("path is required")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", c.Type)
+	}
+	return nil
+}
+
+var stateStore StateStore = annotationStore{}
+
+// newStateStore opens the configured state store.
+func newStateStore(c StateStoreConfig) (StateStore, error) {
+	switch c.Type {
+	case "configmap":
+		parts := strings.SplitN(c.ConfigMap, "/", 2)
+		return &configMapStore{namespace: parts[0], name: parts[1]}, nil
+	case "file":
+		return openFileStore(c.Path)
+	}
+	return annotationStore{}, nil
+}
+
+// recordJob records a transition of the job of a pod. A failure is only
+// logged: the annotations of the pod remain the reference.
+func recordJob(pod *Pod, jobid string, event string, state string, node string) {
+	if dryRun {
+		return
+	}
+	err := stateStore.Record(JobRecord{
+		Time:      time.Now().UTC().Format(time.RFC3339),
+		UID:       pod.Metadata.Uid,
+		Namespace: namespaceOf(pod),
+		Pod:       pod.Metadata.Name,
+		JobID:     jobid,
+		Event:     event,
+		State:     state,
+		Node:      node,
+	})
+	if err != nil {
+		log.Printf("State store: recording %s of job %s of pod %s: %v", event, jobid, pod.Metadata.Name, err)
+	}
+}
+
+// storedJob returns the job last recorded for a pod, or "" if it has none
+// or its job was cleared.
+func storedJob(pod *Pod) (string, error) {
+	records, err := stateStore.Records(pod.Metadata.Uid)
+	if err != nil || len(records) == 0 {
+		return "", err
+	}
+	last := records[len(records)-1]
+	if last.Event == jobCleared {
+		return "", nil
+	}
+	return last.JobID, nil
+}
+
+// recoverJob restores the JobID annotation of a pod whose job is known to
+// the state store but not to the pod. A job PBS no longer knows is cleared,
+// so that the pod is submitted again.
+func recoverJob(pod *Pod) {
+	jobid, err := storedJob(pod)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if jobid == "" {
+		return
+	}
+	_, err = pbsServer.Status(jobid)
+	if err == errUnknownJob {
+		recordJob(pod, jobid, jobCleared, "", "")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	log.Printf("Recovered PBS job %s of pod %s from the state store", jobid, pod.Metadata.Name)
+	err = annotation(pod, jobid)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// activePods maps the UIDs of the pods with a PBS job to the pods, so that
+// the deletion of the pods gone from the API server is recorded. It is nil
+// until the first pass, which starts from the pods of the state store.
+var activePods map[string]*Pod
+
+// recordDeletedPods records the deletion of the pods with a job missing
+// from pods, the pods of the scheduler.
+func recordDeletedPods(pods []Pod) {
+	if activePods == nil {
+		activePods = map[string]*Pod{}
+		records, err := stateStore.Records("")
+		if err != nil {
+			log.Println(err)
+		}
+		last := map[string]JobRecord{}
+		for _, r := range records {
+			last[r.UID] = r
+		}
+		for uid, r := range last {
+			if !finalRecord(r) {
+				activePods[uid] = &Pod{Metadata: Metadata{Uid: uid, Name: r.Pod, Namespace: r.Namespace, Annotations: map[string]string{"JobID": r.JobID}}}
+			}
+		}
+	}
+	live := map[string]bool{}
+	for i := range pods {
+		p := &pods[i]
+		live[p.Metadata.Uid] = true
+		if p.Metadata.Annotations["JobID"] != "" {
+			activePods[p.Metadata.Uid] = &Pod{Metadata: p.Metadata}
+		}
+	}
+	for uid, p := range activePods {
+		if live[uid] {
+			continue
+		}
+		delete(activePods, uid)
+		recordJob(p, p.Metadata.Annotations["JobID"], podDeleted, "", "")
+	}
+}
+
+// finalRecord reports whether the record ends the history of a pod: its
+// job was cleared or finished, or the pod was deleted.
+func finalRecord(r JobRecord) bool {
+	switch r.Event {
+	case jobCleared, jobFinished, podDeleted:
+		return true
+	}
+	return r.State == "F" || r.State == "X"
+}
+
+// expired reports whether the history of a pod ended more than
+// historyRetention ago.
+func expired(records []JobRecord) bool {
+	if len(records) == 0 {
+		return false
+	}
+	last := records[len(records)-1]
+	if !finalRecord(last) {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339, last.Time)
+	return err == nil && time.Since(t) > historyRetention
+}
+
+// annotationStore keeps nothing besides the pod annotations.
+type annotationStore struct{}
+
+func (annotationStore) Record(r JobRecord) error                { return nil }
+func (annotationStore) Records(uid string) ([]JobRecord, error) { return nil, nil }
+
+// maxPodRecords bounds the history kept per pod by the configmap store.
+const maxPodRecords = 20
+
+// configMapShards is the number of ConfigMaps the configmap store spreads
+// the pods over, by hash of their UID.
+const configMapShards = 16
+
+// maxConfigMapBytes bounds the data of each ConfigMap of the configmap
+// store, below the 1MiB limit of the objects of the API server.
+const maxConfigMapBytes = 768 * 1024
+
+// historyRetention is how long the stores keep the history of a pod after
+// its job was cleared or finished, or the pod deleted.
+const historyRetention = 24 * time.Hour
+
+// compactInterval is the minimum time between two compactions of the file
+// store.
+const compactInterval = time.Hour
+
+// configMapStore keeps the history of each pod as a JSON list under the pod
+// UID, in one of configMapShards ConfigMaps named after the configured one.
+// During a scheduling pass each ConfigMap is read once and then served from
+// memory.
+type configMapStore struct {
+	namespace string
+	name      string
+	mu        sync.Mutex
+	// cached holds the ConfigMaps read during the current pass, nil outside
+	// of passes.
+	cached map[string]*ConfigMap
+}
+
+// shard returns the name of the ConfigMap keeping the history of the pod.
+func (s *configMapStore) shard(uid string) string {
+	h := fnv.New32a()
+	h.Write([]byte(uid))
+	return fmt.Sprintf("%s-%d", s.name, h.Sum32()%configMapShards)
+}
+
+// beginPass starts caching the ConfigMaps read until endPass.
+func (s *configMapStore) beginPass() {
+	s.mu.Lock()
+	s.cached = map[string]*ConfigMap{}
+	s.mu.Unlock()
+}
+
+func (s *configMapStore) endPass() {
+	s.mu.Lock()
+	s.cached = nil
+	s.mu.Unlock()
+}
+
+// get returns the ConfigMap, from the cache of the pass unless fresh. A
+// missing ConfigMap is returned empty, without resource version.
+func (s *configMapStore) get(name string, fresh bool) (*ConfigMap, error) {
+	s.mu.Lock()
+	cm, ok := s.cached[name]
+	s.mu.Unlock()
+	if ok && !fresh {
+		return cm, nil
+	}
+	cm, err := getConfigMap(s.namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if cm == nil {
+		cm = &ConfigMap{Metadata: Metadata{Name: name, Namespace: s.namespace}}
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	s.cache(name, cm)
+	return cm, nil
+}
+
+// cache keeps the ConfigMap for the rest of the pass, or forgets it for nil.
+func (s *configMapStore) cache(name string, cm *ConfigMap) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached == nil {
+		return
+	}
+	if cm == nil {
+		delete(s.cached, name)
+		return
+	}
+	s.cached[name] = cm
+}
+
+func (s *configMapStore) Record(r JobRecord) error {
+	name := s.shard(r.UID)
+	for attempt := 0; attempt < 5; attempt++ {
+		cm, err := s.get(name, attempt > 0)
+		if err != nil {
+			return err
+		}
+		var records []JobRecord
+		if data, ok := cm.Data[r.UID]; ok {
+			err = json.Unmarshal([]byte(data), &records)
+			if err != nil {
+				return fmt.Errorf("ConfigMap %s/%s: %s: %v", s.namespace, name, r.UID, err)
+			}
+		}
+		records = append(records, r)
+		if len(records) > maxPodRecords {
+			records = records[len(records)-maxPodRecords:]
+		}
+		data, err := json.Marshal(records)
+		if err != nil {
+			return err
+		}
+		cm.Data[r.UID] = string(data)
+		s.prune(cm, r.UID)
+		s.trim(cm, r.UID)
+		cm.Metadata.Namespace = s.namespace
+		err = saveConfigMap(cm)
+		if err == nil {
+			return nil
+		}
+		// The cached copy was modified and is stale now.
+		s.cache(name, nil)
+		if err != errConfigMapConflict {
+			return err
+		}
+	}
+	return errConfigMapConflict
+}
+
+// prune drops the pods, other than keep, whose history expired.
+func (s *configMapStore) prune(cm *ConfigMap, keep string) {
+	for uid, data := range cm.Data {
+		var records []JobRecord
+		if uid == keep || json.Unmarshal([]byte(data), &records) != nil {
+			continue
+		}
+		if expired(records) {
+			delete(cm.Data, uid)
+		}
+	}
+}
+
+// trim drops the histories of pods other than keep until the data of the
+// ConfigMap fits in maxConfigMapBytes: ended histories first, then the
+// histories last updated the longest ago.
+func (s *configMapStore) trim(cm *ConfigMap, keep string) {
+	size := 0
+	for uid, data := range cm.Data {
+		size += len(uid) + len(data)
+	}
+	if size <= maxConfigMapBytes {
+		return
+	}
+	type history struct {
+		uid   string
+		ended bool
+		last  string
+	}
+	var histories []history
+	for uid, data := range cm.Data {
+		var records []JobRecord
+		if uid == keep {
+			continue
+		}
+		h := history{uid: uid, ended: true}
+		if json.Unmarshal([]byte(data), &records) == nil && len(records) > 0 {
+			last := records[len(records)-1]
+			h.ended, h.last = finalRecord(last), last.Time
+		}
+		histories = append(histories, h)
+	}
+	sort.Slice(histories, func(i, j int) bool {
+		if histories[i].ended != histories[j].ended {
+			return histories[i].ended
+		}
+		return histories[i].last < histories[j].last
+	})
+	dropped := 0
+	for _, h := range histories {
+		if size <= maxConfigMapBytes {
+			break
+		}
+		size -= len(h.uid) + len(cm.Data[h.uid])
+		delete(cm.Data, h.uid)
+		dropped++
+	}
+	log.Printf("State store: ConfigMap %s/%s: dropped the history of %d pods to stay under %d bytes", s.namespace, cm.Metadata.Name, dropped, maxConfigMapBytes)
+}
+
+func (s *configMapStore) Records(uid string) ([]JobRecord, error) {
+	names := []string{s.shard(uid)}
+	if uid == "" {
+		names = nil
+		for i := 0; i < configMapShards; i++ {
+			names = append(names, fmt.Sprintf("%s-%d", s.name, i))
+		}
+	}
+	var all []JobRecord
+	for _, name := range names {
+		cm, err := s.get(name, false)
+		if err != nil {
+			return nil, err
+		}
+		for key, data := range cm.Data {
+			if uid != "" && key != uid {
+				continue
+			}
+			var records []JobRecord
+			err = json.Unmarshal([]byte(data), &records)
+			if err != nil {
+				return nil, fmt.Errorf("ConfigMap %s/%s: %s: %v", s.namespace, name, key, err)
+			}
+			all = append(all, records...)
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Time < all[j].Time })
+	return all, nil
+}
+
+// fileStore is an append only file of JSON records, one per line, indexed
+// by pod UID in memory. Records appended by other processes, such as the
+// admin commands, are read before each lookup. The scheduler compacts the
+// file, dropping the expired histories.
+type fileStore struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	offset    int64
+	records   []JobRecord
+	byUID     map[string][]int
+	compacted time.Time
+}
+
+// openFileStore opens the file store, creating its file if needed.
+func openFileStore(path string) (*fileStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s := &fileStore{path: path, file: f, byUID: map[string][]int{}, compacted: time.Now()}
+	err = s.load()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the complete records appended since the last load.
+func (s *fileStore) load() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() <= s.offset {
+		return nil
+	}
+	buf := make([]byte, info.Size()-s.offset)
+	_, err = s.file.ReadAt(buf, s.offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	end := bytes.LastIndexByte(buf, '\n')
+	if end < 0 {
+		return nil
+	}
+	for _, line := range bytes.Split(buf[:end], []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var r JobRecord
+		err = json.Unmarshal(line, &r)
+		if err != nil {
+			log.Printf("State store: %s: skipping invalid record: %v", s.file.Name(), err)
+			continue
+		}
+		s.byUID[r.UID] = append(s.byUID[r.UID], len(s.records))
+		s.records = append(s.records, r)
+	}
+	s.offset += int64(end + 1)
+	return nil
+}
+
+func (s *fileStore) Record(r JobRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *fileStore) Records(uid string) ([]JobRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if uid == "" {
+		return append([]JobRecord(nil), s.records...), nil
+	}
+	var records []JobRecord
+	for _, i := range s.byUID[uid] {
+		records = append(records, s.records[i])
+	}
+	return records, nil
+}
+
+// compact rewrites the file without the expired histories, at most once per
+// compactInterval. The file is replaced, so records appended by another
+// process still holding the previous file are lost.
+func (s *fileStore) compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.compacted) < compactInterval {
+		return nil
+	}
+	s.compacted = time.Now()
+	err := s.load()
+	if err != nil {
+		return err
+	}
+
+	drop := map[string]bool{}
+	for uid, indices := range s.byUID {
+		var history []JobRecord
+		for _, i := range indices {
+			history = append(history, s.records[i])
+		}
+		drop[uid] = expired(history)
+	}
+	var kept []JobRecord
+	var buf bytes.Buffer
+	for _, r := range s.records {
+		if drop[r.UID] {
+			continue
+		}
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+		kept = append(kept, r)
+	}
+	if len(kept) == len(s.records) {
+		return nil
+	}
+
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, buf.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.file.Close()
+	s.file = f
+	log.Printf("State store: %s: dropped %d expired records", s.path, len(s.records)-len(kept))
+	s.offset = int64(buf.Len())
+	s.records = kept
+	s.byUID = map[string][]int{}
+	for i, r := range kept {
+		s.byUID[r.UID] = append(s.byUID[r.UID], i)
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFileStore opens a file store in a temporary directory as the state
+// store of the scheduler until the test ends.
+func testFileStore(t *testing.T) *fileStore {
+	s, err := openFileStore(t.TempDir() + "/state.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, savedActive := stateStore, activePods
+	stateStore, activePods = s, nil
+	t.Cleanup(func() {
+		stateStore, activePods = saved, savedActive
+		s.file.Close()
+	})
+	return s
+}
+
+func record(uid string, jobid string, event string, age time.Duration) JobRecord {
+	return JobRecord{Time: time.Now().Add(-age).UTC().Format(time.RFC3339), UID: uid, Pod: uid, JobID: jobid, Event: event}
+}
+
+func TestFileStoreRecords(t *testing.T) {
+	s := testFileStore(t)
+	s.Record(record("a", "1.server", jobSubmitted, time.Minute))
+	s.Record(record("b", "2.server", jobSubmitted, time.Minute))
+	s.Record(record("a", "1.server", jobBound, 0))
+
+	records, err := s.Records("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1].Event != jobBound {
+		t.Errorf("records of a: %+v", records)
+	}
+	reopened, err := openFileStore(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.file.Close()
+	all, err := reopened.Records("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Errorf("%d records after reopening, want 3", len(all))
+	}
+}
+
+func TestFileStoreCompact(t *testing.T) {
+	s := testFileStore(t)
+	s.Record(record("deleted", "1.server", jobSubmitted, 48*time.Hour))
+	s.Record(record("deleted", "1.server", podDeleted, 25*time.Hour))
+	s.Record(record("recent", "2.server", jobFinished, time.Hour))
+	s.Record(record("running", "3.server", jobBound, 48*time.Hour))
+
+	err := s.compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all, _ := s.Records(""); len(all) != 4 {
+		t.Fatalf("compacted before compactInterval: %d records", len(all))
+	}
+	s.compacted = time.Time{}
+	err = s.compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Record(record("new", "4.server", jobSubmitted, 0))
+
+	reopened, err := openFileStore(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.file.Close()
+	all, err := reopened.Records("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := map[string]bool{}
+	for _, r := range all {
+		kept[r.UID] = true
+	}
+	if len(all) != 3 || kept["deleted"] || !kept["recent"] || !kept["running"] || !kept["new"] {
+		t.Errorf("records after compaction: %+v", all)
+	}
+}
+
+func TestConfigMapStorePrune(t *testing.T) {
+	cm := &ConfigMap{Data: map[string]string{}}
+	histories := map[string][]JobRecord{
+		"cleared":  {record("cleared", "1.server", jobCleared, 25*time.Hour)},
+		"deleted":  {record("deleted", "2.server", podDeleted, 25*time.Hour)},
+		"finished": {record("finished", "3.server", jobFinished, 25*time.Hour)},
+		"recent":   {record("recent", "4.server", podDeleted, time.Hour)},
+		"bound":    {record("bound", "5.server", jobBound, 25*time.Hour)},
+		"keep":     {record("keep", "6.server", podDeleted, 25*time.Hour)},
+	}
+	for uid, records := range histories {
+		data, _ := json.Marshal(records)
+		cm.Data[uid] = string(data)
+	}
+	(&configMapStore{}).prune(cm, "keep")
+	for _, uid := range []string{"cleared", "deleted", "finished"} {
+		if _, ok := cm.Data[uid]; ok {
+			t.Errorf("expired history of %s kept", uid)
+		}
+	}
+	for _, uid := range []string{"recent", "bound", "keep"} {
+		if _, ok := cm.Data[uid]; !ok {
+			t.Errorf("history of %s pruned", uid)
+		}
+	}
+}
+
+func TestConfigMapStoreShardsAndCachesPasses(t *testing.T) {
+	c := newTestCluster(t)
+	s := &configMapStore{namespace: "kube-system", name: "pbs-state"}
+	uids := []string{"a", "b", "c", "d", "e", "f"}
+	for i, uid := range uids {
+		err := s.Record(record(uid, strconv.Itoa(i)+".server", jobSubmitted, time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	shards := map[string]bool{}
+	for _, uid := range uids {
+		shards[s.shard(uid)] = true
+		if cm, ok := c.api.ConfigMap("kube-system", s.shard(uid)); !ok || cm.Data[uid] == "" {
+			t.Errorf("history of %s not in ConfigMap %s", uid, s.shard(uid))
+		}
+	}
+
+	gets := func() int {
+		n := 0
+		for _, r := range c.api.Requests() {
+			if r.Method == "GET" && strings.Contains(r.Path, "/configmaps/") {
+				n++
+			}
+		}
+		return n
+	}
+	before := gets()
+	s.beginPass()
+	for round := 0; round < 3; round++ {
+		for i, uid := range uids {
+			records, err := s.Records(uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 || records[0].JobID != strconv.Itoa(i)+".server" {
+				t.Errorf("records of %s: %+v", uid, records)
+			}
+		}
+	}
+	err := s.Record(record("a", "0.server", jobBound, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := s.Records("a"); len(records) != 2 {
+		t.Errorf("records of a after an update in the pass: %+v", records)
+	}
+	s.endPass()
+	if n := gets() - before; n != len(shards) {
+		t.Errorf("%d ConfigMap reads in the pass, want one per shard (%d)", n, len(shards))
+	}
+}
+
+func TestConfigMapStoreTrim(t *testing.T) {
+	cm := &ConfigMap{Data: map[string]string{}}
+	pad := strings.Repeat("x", maxConfigMapBytes/4)
+	histories := map[string]JobRecord{
+		"old-running": record("old-running", "1.server", jobBound, 3*time.Hour),
+		"ended":       record("ended", "2.server", podDeleted, time.Minute),
+		"running":     record("running", "3.server", jobBound, time.Hour),
+		"keep":        record("keep", "4.server", jobSubmitted, 5*time.Hour),
+	}
+	for uid, r := range histories {
+		r.Node = pad
+		data, _ := json.Marshal([]JobRecord{r})
+		cm.Data[uid] = string(data)
+	}
+	cm.Data["extra"] = pad
+	(&configMapStore{}).trim(cm, "keep")
+
+	for _, uid := range []string{"extra", "ended"} {
+		if _, ok := cm.Data[uid]; ok {
+			t.Errorf("history of %s kept", uid)
+		}
+	}
+	for _, uid := range []string{"running", "keep"} {
+		if _, ok := cm.Data[uid]; !ok {
+			t.Errorf("history of %s dropped", uid)
+		}
+	}
+	size := 0
+	for uid, data := range cm.Data {
+		size += len(uid) + len(data)
+	}
+	if size > maxConfigMapBytes {
+		t.Errorf("ConfigMap data of %d bytes, want at most %d", size, maxConfigMapBytes)
+	}
+}
+
+func TestRecordDeletedPods(t *testing.T) {
+	s := testFileStore(t)
+	s.Record(record("gone", "1.server", jobBound, time.Hour))
+	s.Record(record("done", "2.server", jobCleared, time.Hour))
+
+	live := Pod{Metadata: Metadata{Uid: "live", Name: "live", Annotations: map[string]string{"JobID": "3.server"}}}
+	recordDeletedPods([]Pod{live})
+	recordDeletedPods(nil)
+
+	for uid, want := range map[string]string{"gone": podDeleted, "done": jobCleared, "live": podDeleted} {
+		records, err := s.Records(uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) == 0 || records[len(records)-1].Event != want {
+			t.Errorf("history of %s: %+v, want it to end with %s", uid, records, want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// statusPoller implements PBS on top of another implementation, serving
+// job status lookups from a snapshot of the jobs of the scheduler, taken
+// with a single qstat and refreshed at most once an interval. The snapshot
+// covers the jobs looked up since the previous one, so that other jobs of
+// the server are never queried; jobs missing from it, such as jobs
+// submitted since, are looked up individually.
+type statusPoller struct {
+	PBS
+	interval time.Duration
+
+	mu    sync.Mutex
+	jobs  map[string]*JobStatus
+	taken time.Time
+	// asked holds the jobs looked up since the snapshot was taken.
+	asked map[string]bool
+}
+
+func newStatusPoller(pbs PBS, interval time.Duration) *statusPoller {
+	return &statusPoller{PBS: pbs, interval: interval, jobs: map[string]*JobStatus{}, asked: map[string]bool{}}
+}
+
+// statusInterval returns the refresh interval of the status snapshot, or 0
+// if each lookup runs qstat.
+func statusInterval() time.Duration {
+	switch {
+	case config.StatusInterval < 0:
+		return 0
+	case config.StatusInterval == 0:
+		return 10 * time.Second
+	}
+	return time.Duration(config.StatusInterval) * time.Second
+}
+
+// refresh replaces the snapshot if it is older than the interval; p.mu
+// must be held.
+func (p *statusPoller) refresh() {
+	if time.Since(p.taken) < p.interval {
+		return
+	}
+	var jobids []string
+	for jobid := range p.asked {
+		jobids = append(jobids, jobid)
+	}
+	sort.Strings(jobids)
+	p.jobs = map[string]*JobStatus{}
+	p.asked = map[string]bool{}
+	p.taken = time.Now()
+	if len(jobids) == 0 {
+		return
+	}
+	statuses, err := p.PBS.StatusJobs(jobids)
+	if err != nil {
+		// Jobs are looked up individually until the next refresh.
+		log.Println(err)
+		return
+	}
+	for _, s := range statuses {
+		p.jobs[s.ID] = s
+	}
+}
+
+func (p *statusPoller) Status(jobid string) (*JobStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refresh()
+	p.asked[jobid] = true
+	if s, ok := p.jobs[jobid]; ok {
+		return s, nil
+	}
+	s, err := p.PBS.Status(jobid)
+	if err != nil {
+		return nil, err
+	}
+	p.jobs[jobid] = s
+	return s, nil
+}
+
+func (p *statusPoller) Delete(jobid string) error {
+	p.forget(jobid)
+	return p.PBS.Delete(jobid)
+}
+
+func (p *statusPoller) Release(jobid string) error {
+	p.forget(jobid)
+	return p.PBS.Release(jobid)
+}
+
+// forget drops a job changed by the scheduler from the snapshot.
+func (p *statusPoller) forget(jobid string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.jobs, jobid)
+}
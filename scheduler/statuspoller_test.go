@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// countingPBS records the jobs queried through a PBS.
+type countingPBS struct {
+	PBS
+	lookups []string
+	batches [][]string
+}
+
+func (c *countingPBS) Status(jobid string) (*JobStatus, error) {
+	c.lookups = append(c.lookups, jobid)
+	return c.PBS.Status(jobid)
+}
+
+func (c *countingPBS) StatusJobs(jobids []string) ([]*JobStatus, error) {
+	c.batches = append(c.batches, jobids)
+	return c.PBS.StatusJobs(jobids)
+}
+
+func TestStatusPollerQueriesOnlyLookedUpJobs(t *testing.T) {
+	fake := NewFakePBS(&FakePBSNode{Name: "node1", Ncpus: 4, MemMB: 1024})
+	var jobids []string
+	for i := 0; i < 3; i++ {
+		jobid, err := fake.Submit(Submission{Args: []string{"-l", "select=1:ncpus=1:mem=100MB"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobids = append(jobids, jobid)
+	}
+	pbs := &countingPBS{PBS: fake}
+	p := newStatusPoller(pbs, time.Hour)
+
+	for _, jobid := range jobids[:2] {
+		if _, err := p.Status(jobid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pbs.batches) != 0 || len(pbs.lookups) != 2 {
+		t.Fatalf("first lookups: %d batches, %d lookups, want 0 and 2", len(pbs.batches), len(pbs.lookups))
+	}
+
+	p.taken = time.Time{}
+	if _, err := p.Status(jobids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{jobids[:2]}; !reflect.DeepEqual(pbs.batches, want) {
+		t.Errorf("snapshot queried %v, want %v", pbs.batches, want)
+	}
+	if len(pbs.lookups) != 2 {
+		t.Errorf("%d individual lookups, want the snapshot to serve the job", len(pbs.lookups))
+	}
+}
@@ -39,6 +39,7 @@
 package main
 
 type Event struct {
+	Action         string          `json:"action,omitempty"`
 	ApiVersion     string          `json:"apiVersion,omitempty"`
 	Count          int64           `json:"count,omitempty"`
 	FirstTimestamp string          `json:"firstTimestamp"`
@@ -52,6 +53,26 @@ type Event struct {
 	Type           string          `json:"type,omitempty"`
 }
 
+type EventV1 struct {
+	ApiVersion          string          `json:"apiVersion"`
+	Kind                string          `json:"kind"`
+	Metadata            Metadata        `json:"metadata"`
+	EventTime           string          `json:"eventTime"`
+	Series              *EventSeries    `json:"series,omitempty"`
+	ReportingController string          `json:"reportingController"`
+	ReportingInstance   string          `json:"reportingInstance"`
+	Action              string          `json:"action"`
+	Reason              string          `json:"reason"`
+	Regarding           ObjectReference `json:"regarding"`
+	Note                string          `json:"note,omitempty"`
+	Type                string          `json:"type"`
+}
+
+type EventSeries struct {
+	Count            int64  `json:"count"`
+	LastObservedTime string `json:"lastObservedTime"`
+}
+
 type EventSource struct {
 	Component string `json:"component,omitempty"`
 	Host      string `json:"host,omitempty"`
@@ -78,14 +99,82 @@ type PodWatchEvent struct {
 }
 
 type Pod struct {
-	Kind     string   `json:"kind,omitempty"`
-	Metadata Metadata `json:"metadata"`
-	Spec     PodSpec  `json:"spec"`
+	Kind     string    `json:"kind,omitempty"`
+	Metadata Metadata  `json:"metadata"`
+	Spec     PodSpec   `json:"spec"`
+	Status   PodStatus `json:"status,omitempty"`
+}
+
+type PodStatus struct {
+	Phase             string            `json:"phase,omitempty"`
+	Conditions        []PodCondition    `json:"conditions,omitempty"`
+	ContainerStatuses []ContainerStatus `json:"containerStatuses,omitempty"`
+}
+
+type ContainerStatus struct {
+	Name  string         `json:"name"`
+	State ContainerState `json:"state"`
+}
+
+type ContainerState struct {
+	Terminated *ContainerStateTerminated `json:"terminated,omitempty"`
+}
+
+type ContainerStateTerminated struct {
+	ExitCode int    `json:"exitCode"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type PodCondition struct {
+	Type               string `json:"type"`
+	Status             string `json:"status"`
+	Reason             string `json:"reason,omitempty"`
+	Message            string `json:"message,omitempty"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
 }
 
 type PodSpec struct {
-	NodeName   string      `json:"nodeName"`
-	Containers []Container `json:"containers"`
+	NodeName     string            `json:"nodeName"`
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	Affinity     *Affinity         `json:"affinity,omitempty"`
+	Tolerations  []Toleration      `json:"tolerations,omitempty"`
+	Containers   []Container       `json:"containers"`
+
+	Priority          *int32 `json:"priority,omitempty"`
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	SchedulerName      string `json:"schedulerName,omitempty"`
+}
+
+type Affinity struct {
+	NodeAffinity *NodeAffinity `json:"nodeAffinity,omitempty"`
+}
+
+type NodeAffinity struct {
+	RequiredDuringSchedulingIgnoredDuringExecution *NodeSelector `json:"requiredDuringSchedulingIgnoredDuringExecution,omitempty"`
+}
+
+type NodeSelector struct {
+	NodeSelectorTerms []NodeSelectorTerm `json:"nodeSelectorTerms"`
+}
+
+type NodeSelectorTerm struct {
+	MatchExpressions []NodeSelectorRequirement `json:"matchExpressions,omitempty"`
+	MatchFields      []NodeSelectorRequirement `json:"matchFields,omitempty"`
+}
+
+type NodeSelectorRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+type Toleration struct {
+	Key      string `json:"key,omitempty"`
+	Operator string `json:"operator,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Effect   string `json:"effect,omitempty"`
 }
 
 type Container struct {
@@ -100,6 +189,99 @@ type ResourceRequirements struct {
 
 type ResourceList map[string]string
 
+type NodeList struct {
+	ApiVersion string       `json:"apiVersion"`
+	Kind       string       `json:"kind"`
+	Metadata   ListMetadata `json:"metadata"`
+	Items      []Node       `json:"items"`
+}
+
+type Node struct {
+	Kind     string   `json:"kind,omitempty"`
+	Metadata Metadata `json:"metadata"`
+	Spec     NodeSpec `json:"spec"`
+}
+
+type NodeSpec struct {
+	Unschedulable bool    `json:"unschedulable,omitempty"`
+	Taints        []Taint `json:"taints,omitempty"`
+}
+
+type Taint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+type ConfigMap struct {
+	ApiVersion string            `json:"apiVersion,omitempty"`
+	Kind       string            `json:"kind,omitempty"`
+	Metadata   Metadata          `json:"metadata"`
+	Data       map[string]string `json:"data"`
+}
+
+// PBSJobTemplate is the PBSJobTemplate custom resource, a set of PBS job
+// options pods reference by name with the PBSJobTemplate annotation.
+type PBSJobTemplate struct {
+	ApiVersion string             `json:"apiVersion,omitempty"`
+	Kind       string             `json:"kind,omitempty"`
+	Metadata   Metadata           `json:"metadata"`
+	Spec       PBSJobTemplateSpec `json:"spec"`
+}
+
+type PBSJobTemplateSpec struct {
+	Queue       string            `json:"queue,omitempty"`
+	Select      string            `json:"select,omitempty"`
+	Place       string            `json:"place,omitempty"`
+	Walltime    string            `json:"walltime,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	Environment map[string]string `json:"environment,omitempty"`
+	Hooks       PBSJobHooks       `json:"hooks,omitempty"`
+}
+
+type PBSJobHooks struct {
+	Prologue string `json:"prologue,omitempty"`
+	Epilogue string `json:"epilogue,omitempty"`
+}
+
+type PBSJobTemplateList struct {
+	Items []PBSJobTemplate `json:"items"`
+}
+
+type PBSJobTemplateWatchEvent struct {
+	Type   string         `json:"type"`
+	Object PBSJobTemplate `json:"object"`
+}
+
+type AdmissionReview struct {
+	ApiVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *AdmissionRequest  `json:"request,omitempty"`
+	Response   *AdmissionResponse `json:"response,omitempty"`
+}
+
+type AdmissionRequest struct {
+	Uid       string `json:"uid"`
+	Namespace string `json:"namespace"`
+	Operation string `json:"operation"`
+	Object    Pod    `json:"object"`
+}
+
+type AdmissionResponse struct {
+	Uid      string           `json:"uid"`
+	Allowed  bool             `json:"allowed"`
+	Result   *AdmissionStatus `json:"status,omitempty"`
+	Warnings []string         `json:"warnings,omitempty"`
+	// Patch is a JSON patch of the object, base64 encoded by encoding/json.
+	Patch     []byte `json:"patch,omitempty"`
+	PatchType string `json:"patchType,omitempty"`
+}
+
+type AdmissionStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
 type Binding struct {
 	ApiVersion string   `json:"apiVersion"`
 	Kind       string   `json:"kind"`
@@ -107,6 +289,12 @@ type Binding struct {
 	Metadata   Metadata `json:"metadata"`
 }
 
+type Eviction struct {
+	ApiVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Metadata   Metadata `json:"metadata"`
+}
+
 type Target struct {
 	ApiVersion string `json:"apiVersion"`
 	Kind       string `json:"kind"`
@@ -118,10 +306,22 @@ type ListMetadata struct {
 }
 
 type Metadata struct {
-	Name            string            `json:"name"`
-	GenerateName    string            `json:"generateName"`
-	ResourceVersion string            `json:"resourceVersion"`
-	Labels          map[string]string `json:"labels"`
-	Annotations     map[string]string `json:"annotations"`
-	Uid             string            `json:"uid"`
+	Name              string            `json:"name"`
+	GenerateName      string            `json:"generateName"`
+	Namespace         string            `json:"namespace,omitempty"`
+	ResourceVersion   string            `json:"resourceVersion"`
+	Labels            map[string]string `json:"labels"`
+	Annotations       map[string]string `json:"annotations"`
+	Uid               string            `json:"uid"`
+	CreationTimestamp string            `json:"creationTimestamp,omitempty"`
+	DeletionTimestamp string            `json:"deletionTimestamp,omitempty"`
+	OwnerReferences   []OwnerReference  `json:"ownerReferences,omitempty"`
+}
+
+type OwnerReference struct {
+	ApiVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Uid        string `json:"uid"`
+	Controller *bool  `json:"controller,omitempty"`
 }
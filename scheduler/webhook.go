@@ -0,0 +1,212 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// WebhookConfig enables the admission webhooks served by the scheduler.
+// The API server only calls webhooks over TLS.
+type WebhookConfig struct {
+	// Listen is the host:port address of the webhooks.
+	Listen   string `json:"listen"`
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+func (c *WebhookConfig) validate() error {
+	if c.Listen != "" && (c.CertFile == "" || c.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file are required")
+	}
+	return nil
+}
+
+// serveWebhooks serves the admission webhooks until done is closed.
+func serveWebhooks(c WebhookConfig, done chan struct{}, wg *sync.WaitGroup) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", func(w http.ResponseWriter, r *http.Request) {
+		serveAdmission(w, r, validatePodAdmission)
+	})
+	mux.HandleFunc("/mutate", func(w http.ResponseWriter, r *http.Request) {
+		serveAdmission(w, r, mutatePodAdmission)
+	})
+	server := &http.Server{Addr: c.Listen, Handler: mux}
+	go func() {
+		<-done
+		server.Close()
+	}()
+	err := server.ListenAndServeTLS(c.CertFile, c.KeyFile)
+	if err != http.ErrServerClosed {
+		log.Println(err)
+	}
+	wg.Done()
+	log.Println("Stopped admission webhooks.")
+}
+
+// serveAdmission decodes an AdmissionReview and answers it with the
+// response of review.
+func serveAdmission(w http.ResponseWriter, r *http.Request, review func(*AdmissionRequest) *AdmissionResponse) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var ar AdmissionReview
+	err := json.NewDecoder(r.Body).Decode(&ar)
+	if err != nil || ar.Request == nil {
+		http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+		return
+	}
+	res := review(ar.Request)
+	res.Uid = ar.Request.Uid
+	writeJSON(w, http.StatusOK, AdmissionReview{
+		ApiVersion: ar.ApiVersion,
+		Kind:       ar.Kind,
+		Response:   res,
+	})
+}
+
+// admissionPod returns the pod of an admission request, named after its
+// generateName when the API server has not named it yet.
+func admissionPod(req *AdmissionRequest) *Pod {
+	pod := req.Object
+	if pod.Metadata.Namespace == "" {
+		pod.Metadata.Namespace = req.Namespace
+	}
+	if pod.Metadata.Name == "" {
+		pod.Metadata.Name = pod.Metadata.GenerateName
+	}
+	return &pod
+}
+
+func validatePodAdmission(req *AdmissionRequest) *AdmissionResponse {
+	if req.Operation != "CREATE" {
+		return &AdmissionResponse{Allowed: true}
+	}
+	pod := admissionPod(req)
+	msg, err := validatePod(pod)
+	if err != nil {
+		log.Println(err)
+		return &AdmissionResponse{
+			Allowed:  true,
+			Warnings: []string{"PBS scheduler could not validate the pod: " + err.Error()},
+		}
+	}
+	if msg != "" {
+		return &AdmissionResponse{
+			Allowed: false,
+			Result:  &AdmissionStatus{Code: http.StatusForbidden, Message: msg},
+		}
+	}
+	return &AdmissionResponse{Allowed: true}
+}
+
+// validatePod returns why PBS can never run the pod, or "". It runs the
+// translation of fit and checks the request against the limits of the
+// destination queue and the capacity of the PBS vnodes. Pods selecting
+// another scheduler are not checked.
+func validatePod(pod *Pod) (string, error) {
+	if pod.Spec.SchedulerName != schedulerName() || pod.Metadata.Annotations[managedAnnotation] == "false" {
+		return "", nil
+	}
+	req, err := podJobRequest(pod)
+	if r, ok := err.(*rejection); ok {
+		return r.msg, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if name := req.queue(); name != "" {
+		queues, err := pbsServer.Queues()
+		if err != nil {
+			return "", err
+		}
+		var queue *PBSObject
+		for _, q := range queues {
+			if q.Name == name {
+				queue = q
+			}
+		}
+		if queue == nil {
+			return fmt.Sprintf("pod (%s) is submitted to PBS queue %s, which does not exist", pod.Metadata.Name, name), nil
+		}
+		if queue.Attributes["enabled"] == "False" {
+			return fmt.Sprintf("pod (%s) is submitted to PBS queue %s, which is disabled", pod.Metadata.Name, name), nil
+		}
+		if max, err := strconv.Atoi(queue.Attributes["resources_max.ncpus"]); err == nil && req.ncpus > max {
+			return fmt.Sprintf("pod (%s) requests %d cpus, above the limit of %d of PBS queue %s", pod.Metadata.Name, req.ncpus, max, name), nil
+		}
+		if max, err := parseMemMB(queue.Attributes["resources_max.mem"]); err == nil && req.memMB > max {
+			return fmt.Sprintf("pod (%s) requests %dMB of memory, above the limit of %s of PBS queue %s", pod.Metadata.Name, req.memMB, queue.Attributes["resources_max.mem"], name), nil
+		}
+	}
+
+	nodes, err := pbsServer.Nodes()
+	if err != nil || len(nodes) == 0 {
+		return "", err
+	}
+	maxNcpus, maxMemMB := 0, 0
+	for _, n := range nodes {
+		ncpus, _ := strconv.Atoi(n.Attributes["resources_available.ncpus"])
+		memMB, _ := parseMemMB(n.Attributes["resources_available.mem"])
+		if ncpus >= req.ncpus && memMB >= req.memMB {
+			return "", nil
+		}
+		if ncpus > maxNcpus {
+			maxNcpus = ncpus
+		}
+		if memMB > maxMemMB {
+			maxMemMB = memMB
+		}
+	}
+	return fmt.Sprintf("pod (%s) requests %d cpus and %dMB of memory, more than any PBS node offers (at most %d cpus and %dMB)", pod.Metadata.Name, req.ncpus, req.memMB, maxNcpus, maxMemMB), nil
+}
+
+// writeJSON writes obj as the JSON body of a response with the status.
+func writeJSON(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(obj)
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePod(t *testing.T) {
+	newTestCluster(t, "node1")
+	tests := []struct {
+		name      string
+		scheduler string
+		cpu       string
+		memory    string
+		rejected  string
+	}{
+		{"fits", "", "2", "100Mi", ""},
+		{"millicpus", "", "500m", "1Gi", ""},
+		{"above every vnode", "", "8", "100Mi", "8 cpus"},
+		{"memory in Gi above every vnode", "", "1", "16Gi", "16384MB"},
+		{"memory in G above every vnode", "", "1", "9G", "8584MB"},
+		{"unknown memory unit", "", "1", "2GB", "resource quantity"},
+		{"no cpu request", "", "", "100Mi", "cpu request"},
+		{"other scheduler", "default-scheduler", "", "", ""},
+	}
+	for _, tt := range tests {
+		pod := testPod("web", tt.cpu, tt.memory)
+		pod.Metadata.Namespace = "default"
+		if tt.scheduler != "" {
+			pod.Spec.SchedulerName = tt.scheduler
+		}
+		msg, err := validatePod(&pod)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if tt.rejected == "" && msg != "" {
+			t.Errorf("%s: rejected: %s", tt.name, msg)
+		}
+		if tt.rejected != "" && !strings.Contains(msg, tt.rejected) {
+			t.Errorf("%s: rejection %q, want it to mention %q", tt.name, msg, tt.rejected)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 1994-2019 Altair Engineering, Inc.
+ * For more information, contact Altair at www.altair.com.
+ *
+ * This file is part of the PBS Professional ("PBS Pro") software.
+ *
+ * Open Source License Information:
+ *
+ * PBS Pro is free software. You can redistribute it and/or modify it under the
+ * terms of the GNU Affero General Public License as published by the Free
+ * Software Foundation, either version 3 of the License, or (at your option) any
+ * later version.
+ *
+ * PBS Pro is distributed in the hope that it will be useful, but WITHOUT ANY
+ * WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+ * FOR A PARTICULAR PURPOSE.
+ * See the GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ * Commercial License Information:
+ *
+ * For a copy of the commercial license terms and conditions,
+ * go to: (http://www.pbspro.com/UserArea/agreement.html)
+ * or contact the Altair Legal Department.
+ *
+ * Altair’s dual-license business model allows companies, individuals, and
+ * organizations to create proprietary derivative works of PBS Pro and
+ * distribute them - whether embedded or bundled with other software -
+ * under a commercial license agreement.
+ *
+ * Use of Altair’s trademarks, including but not limited to "PBS™",
+ * "PBS Professional®", and "PBS Pro™" and Altair’s logos is subject to Altair's
+ * trademark licensing policies.
+ *
+ */
+
+package main
+
+import "strings"
+
+// maxJobName is the longest job name PBS accepts.
+const maxJobName = 236
+
+// Workload is the Kubernetes workload controlling a pod.
+type Workload struct {
+	Kind string
+	Name string
+}
+
+func (w *Workload) String() string {
+	return w.Kind + "/" + w.Name
+}
+
+// podWorkload returns the Job, Deployment or StatefulSet controlling the
+// pod, or nil for standalone pods and other controllers. The Deployment of a
+// ReplicaSet is found from the pod-template-hash suffix of its name.
+func podWorkload(pod *Pod) *Workload {
+	for _, ref := range pod.Metadata.OwnerReferences {
+		if ref.Controller == nil || !*ref.Controller {
+			continue
+		}
+		switch ref.Kind {
+		case "Job", "StatefulSet":
+			return &Workload{Kind: ref.Kind, Name: ref.Name}
+		case "ReplicaSet":
+			hash := pod.Metadata.Labels["pod-template-hash"]
+			if hash == "" || !strings.HasSuffix(ref.Name, "-"+hash) {
+				return nil
+			}
+			return &Workload{Kind: "Deployment", Name: strings.TrimSuffix(ref.Name, "-"+hash)}
+		}
+		return nil
+	}
+	return nil
+}
+
+// jobName returns the PBS job name of the pod. Pods of a Deployment are
+// named after the Deployment and their random suffix, so that the jobs of
+// all its replicas share a prefix across rollouts; other pods, including
+// the stable names of StatefulSet pods, keep their name.
+func jobName(pod *Pod, w *Workload) string {
+	name := pod.Metadata.Name
+	if w != nil && w.Kind == "Deployment" {
+		for _, ref := range pod.Metadata.OwnerReferences {
+			if ref.Kind == "ReplicaSet" && strings.HasPrefix(name, ref.Name+"-") {
+				name = w.Name + "-" + strings.TrimPrefix(name, ref.Name+"-")
+				break
+			}
+		}
+	}
+	if len(name) > maxJobName {
+		name = name[:maxJobName]
+	}
+	return name
+}
+
+// workloadVariables returns the PODWORKLOAD job variable recording the
+// workload of the pod, in the format of qsub -v.
+func workloadVariables(w *Workload) string {
+	if w == nil {
+		return ""
+	}
+	return ",PODWORKLOAD=" + w.String()
+}
+
+// workloadPolicy gives the pods of a Deployment a PBS project named after
+// it, unless the namespace policy sets one.
+func workloadPolicy(w *Workload) NamespacePolicy {
+	if w == nil || w.Kind != "Deployment" {
+		return NamespacePolicy{}
+	}
+	return NamespacePolicy{Project: w.Name}
+}